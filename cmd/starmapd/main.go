@@ -0,0 +1,66 @@
+// +build !appengine
+
+/*
+standalone starmap WMS server
+serves WMS requests on /wms and static web content on /
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"starmap"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	dataDir := flag.String("data", "data",
+		"directory containing star catalog and constellation data")
+	templateDir := flag.String("templates", "templates",
+		"directory containing response templates")
+	webDir := flag.String("web", "web", "directory containing static web content")
+	cacheType := flag.String("cache", "memory",
+		"tile cache type: none, memory or disk")
+	cacheSize := flag.Int64("cache-size", 64,
+		"maximum size of memory tile cache in megabytes")
+	cacheDir := flag.String("cache-dir", "tiles",
+		"directory for disk tile cache")
+	preload := flag.Bool("preload", false,
+		"load all star catalog tiers at startup")
+	flag.Parse()
+
+	cache, err := createCache(*cacheType, *cacheSize, *cacheDir)
+	if err != nil {
+		log.Fatalf("Unable to create tile cache: %v", err)
+	}
+	starmap.SetTileCache(cache)
+
+	if err := starmap.Load(*dataDir, *templateDir); err != nil {
+		log.Fatalf("Unable to load starmap data: %v", err)
+	}
+	if *preload {
+		if err := starmap.Preload(); err != nil {
+			log.Fatalf("Unable to load star catalog: %v", err)
+		}
+	}
+	http.HandleFunc("/wms", starmap.Handler)
+	http.Handle("/", http.FileServer(http.Dir(*webDir)))
+	log.Printf("Listening on %v", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+/* create tile cache from command line options */
+func createCache(cacheType string, sizeMB int64,
+	dir string) (starmap.TileCache, error) {
+	switch cacheType {
+	case "none":
+		return starmap.NoCache{}, nil
+	case "memory":
+		return starmap.NewLRUCache(sizeMB * 1024 * 1024), nil
+	case "disk":
+		return starmap.NewDiskCache(dir)
+	}
+	return nil, fmt.Errorf("Unknown cache type: %v", cacheType)
+}
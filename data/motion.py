@@ -0,0 +1,43 @@
+#!/usr/bin/env python
+
+import sys
+
+if len(sys.argv) < 3 :
+    print("Usage: " + sys.argv[0] +
+            " [input file] [hip_main.dat] [radial velocity file]")
+    sys.exit(1)
+
+# appends proper motion in ra * cos(dec) and dec and parallax in mas from
+# the hipparcos main catalog (cds I/239 hip_main.dat) to each star of a tier
+# file, then radial velocity in km/s from an optional tab separated file of
+# hipparcos number and velocity. stars that already have them are unchanged
+
+fname = sys.argv[1]
+astrometry = {}
+with open(sys.argv[2]) as src:
+    for line in src:
+        parts = line.split("|")
+        if len(parts) < 14 or not parts[11].strip():
+            continue
+        astrometry[parts[1].strip()] = [parts[12].strip(), parts[13].strip(),
+                parts[11].strip()]
+
+velocity = {}
+if len(sys.argv) > 3 :
+    with open(sys.argv[3]) as src:
+        for line in src:
+            parts = line.split()
+            if len(parts) >= 2:
+                velocity[parts[0]] = parts[1]
+
+with open(fname, newline="") as src, open("out.tsv", "w", newline="") as out:
+    for line in src:
+        body = line.rstrip("\r\n")
+        parts = body.split("\t")
+        hip = parts[1].strip() if len(parts) > 1 else ""
+        if len(parts) == 6 and hip in astrometry:
+            cols = astrometry[hip]
+            if hip in velocity:
+                cols = cols + [velocity[hip]]
+            body = "\t".join(parts + cols)
+        out.write(body + line[len(line.rstrip("\r\n")):])
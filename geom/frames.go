@@ -0,0 +1,222 @@
+package geom
+
+import (
+	"math"
+)
+
+/* J2000 mean obliquity of the ecliptic in degrees */
+const Obliquity = 23.4392911
+
+/*
+sky coordinate frame defined by a rotation, or a reflection, from J2000
+equatorial coordinates. longitude and latitude are in degrees
+*/
+type Frame struct {
+	/* rotates equatorial unit vectors into the frame */
+	m [3][3]float64
+}
+
+/* J2000 equatorial coordinates in degrees */
+var EquatorialFrame = &Frame{[3][3]float64{
+	{1, 0, 0},
+	{0, 1, 0},
+	{0, 0, 1},
+}}
+
+/* galactic coordinates, rotation from the hipparcos catalogue */
+var GalacticFrame = &Frame{[3][3]float64{
+	{-0.0548755604162154, -0.8734370902348850, -0.4838350155487132},
+	{+0.4941094278755837, -0.4448296299600112, +0.7469822444972189},
+	{-0.8676661490190047, -0.1980763734312015, +0.4559837761750669},
+}}
+
+/* ecliptic coordinates for the J2000 mean equinox */
+var EclipticFrame = newEclipticFrame(Obliquity)
+
+/* create ecliptic frame for obliquity in degrees */
+func newEclipticFrame(obliquity float64) *Frame {
+	sin, cos := math.Sincos(toRadians(obliquity))
+	return &Frame{[3][3]float64{
+		{1, 0, 0},
+		{0, cos, sin},
+		{0, -sin, cos},
+	}}
+}
+
+/*
+returns true if the frame is a reflection of equatorial coordinates instead
+of a rotation. longitude increases clockwise around the frame's north pole
+seen from inside the sky, like azimuth, so it is drawn increasing to the
+right where right ascension is drawn increasing to the left
+*/
+func (f *Frame) Mirrored() bool {
+	m := f.m
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	return det < 0
+}
+
+/* returns matrix product a times b, rotating by b then a */
+func multiply(a, b [3][3]float64) [3][3]float64 {
+	var rval [3][3]float64
+	for i := 0; i < 3; i += 1 {
+		for j := 0; j < 3; j += 1 {
+			rval[i][j] = a[i][0]*b[0][j] + a[i][1]*b[1][j] + a[i][2]*b[2][j]
+		}
+	}
+	return rval
+}
+
+/* takes in longitude and latitude in degrees returns unit vector */
+func toVector(lon, lat float64) [3]float64 {
+	sinLon, cosLon := math.Sincos(toRadians(lon))
+	sinLat, cosLat := math.Sincos(toRadians(lat))
+	return [3]float64{cosLat * cosLon, cosLat * sinLon, sinLat}
+}
+
+/* takes in a vector returns longitude in [0, 360) and latitude in degrees */
+func fromVector(v [3]float64) (float64, float64) {
+	lon := toDegrees(math.Atan2(v[1], v[0]))
+	if lon < 0 {
+		lon += 360
+	}
+	lat := toDegrees(math.Atan2(v[2], math.Hypot(v[0], v[1])))
+	return lon, lat
+}
+
+/* takes in right ascension and declination in degrees
+returns longitude and latitude in frame */
+func (f *Frame) FromEquatorial(ra, dec float64) (float64, float64) {
+	v := toVector(ra, dec)
+	var r [3]float64
+	for i := 0; i < 3; i += 1 {
+		r[i] = f.m[i][0]*v[0] + f.m[i][1]*v[1] + f.m[i][2]*v[2]
+	}
+	return fromVector(r)
+}
+
+/* takes in longitude and latitude in frame
+returns right ascension and declination in degrees */
+func (f *Frame) ToEquatorial(lon, lat float64) (float64, float64) {
+	v := toVector(lon, lat)
+	var r [3]float64
+	/* inverse of a rotation is its transpose */
+	for i := 0; i < 3; i += 1 {
+		r[i] = f.m[0][i]*v[0] + f.m[1][i]*v[1] + f.m[2][i]*v[2]
+	}
+	return fromVector(r)
+}
+
+/* takes in frame
+returns this equatorial point, right ascension in hours, as longitude
+and latitude in frame */
+func (p *Point) ToFrame(f *Frame) *Point {
+	lon, lat := f.FromEquatorial(p.X()*DegreesPerHour, p.Y())
+	return NewPoint2D(lon, lat)
+}
+
+/* takes in frame
+returns this point in frame as an equatorial point in hours and degrees */
+func (p *Point) FromFrame(f *Frame) *Point {
+	ra, dec := f.ToEquatorial(p.X(), p.Y())
+	return NewPoint2D(ra/DegreesPerHour, dec)
+}
+
+/* returns this equatorial point in galactic coordinates */
+func (p *Point) ToGalactic() *Point {
+	return p.ToFrame(GalacticFrame)
+}
+
+/* returns this galactic point in equatorial coordinates */
+func (p *Point) FromGalactic() *Point {
+	return p.FromFrame(GalacticFrame)
+}
+
+/* returns this equatorial point in ecliptic coordinates */
+func (p *Point) ToEcliptic() *Point {
+	return p.ToFrame(EclipticFrame)
+}
+
+/* returns this ecliptic point in equatorial coordinates */
+func (p *Point) FromEcliptic() *Point {
+	return p.FromFrame(EclipticFrame)
+}
+
+/*
+takes in frame and a bounding box of longitude and latitude in frame,
+longitude may extend past 0 or 360 degrees
+returns equatorial bounds in hours and degrees that cover the box.
+right ascension may extend past 0h or 24h, see SplitBBox2D()
+*/
+func EquatorialBounds(f *Frame, bbox *BoundingBox) *BoundingBox {
+	return transformBounds(bbox, DEGREES, STELLAR, f.toEquatorialPoint,
+		f.fromEquatorialPoint)
+}
+
+/*
+takes in frame and equatorial bounding box in hours and degrees, right
+ascension may extend past 0h or 24h
+returns bounds of longitude and latitude in frame that cover the box.
+longitude may extend past 0 or 360 degrees
+*/
+func FrameBounds(f *Frame, bbox *BoundingBox) *BoundingBox {
+	return transformBounds(bbox, STELLAR, DEGREES, f.fromEquatorialPoint,
+		f.toEquatorialPoint)
+}
+
+/* see Point.ToFrame() */
+func (f *Frame) fromEquatorialPoint(p *Point) *Point {
+	return p.ToFrame(f)
+}
+
+/* see Point.FromFrame() */
+func (f *Frame) toEquatorialPoint(p *Point) *Point {
+	return p.FromFrame(f)
+}
+
+/*
+takes in bounding box on grid in, the grid out and conversions of points
+between them
+returns bounds on grid out that cover the box by sampling its edges.
+x may extend past the edges of out
+*/
+func transformBounds(bbox *BoundingBox, in, out *GridDef,
+	to, from func(*Point) *Point) *BoundingBox {
+	lower, upper := bbox.Lower(), bbox.Upper()
+	x0, y0, x1, y1 := lower.X(), lower.Y(), upper.X(), upper.Y()
+	center := to(NewPoint2D((x0+x1)/2, (y0+y1)/2))
+	minx, miny := math.Inf(1), math.Inf(1)
+	maxx, maxy := math.Inf(-1), math.Inf(-1)
+	for i := 0; i <= edgeSamples; i += 1 {
+		s := float64(i) / edgeSamples
+		x := x0 + (x1-x0)*s
+		y := y0 + (y1-y0)*s
+		for _, e := range [][2]float64{{x, y0}, {x, y1}, {x0, y}, {x1, y}} {
+			p := to(NewPoint2D(e[0], e[1]))
+			px := out.NearestX(p.X(), center.X())
+			minx, maxx = math.Min(minx, px), math.Max(maxx, px)
+			miny, maxy = math.Min(miny, p.Y()), math.Max(maxy, p.Y())
+		}
+	}
+	outMin, outMax := out.XRange()
+	/* a box around a pole covers every x */
+	for _, lat := range []float64{-90, 90} {
+		pole := from(NewPoint2D(0, lat))
+		px := in.NearestX(pole.X(), (x0+x1)/2)
+		if px >= x0 && px <= x1 && pole.Y() >= y0 && pole.Y() <= y1 {
+			miny, maxy = math.Min(miny, lat), math.Max(maxy, lat)
+			minx, maxx = outMin, outMax
+		}
+	}
+	/* edges of a box bend away from the equator between samples */
+	step := math.Max((x1-x0)*in.xDegrees(), y1-y0) / edgeSamples
+	miny = math.Max(miny-step, -90)
+	maxy = math.Min(maxy+step, 90)
+	minx -= step / out.xDegrees()
+	maxx += step / out.xDegrees()
+	if maxx-minx >= outMax-outMin {
+		minx, maxx = outMin, outMax
+	}
+	return NewBBox2D(minx, miny, maxx, maxy)
+}
@@ -3,6 +3,7 @@ package geom
 import (
 	"bytes"
 	"fmt"
+	"math"
 	"strings"
 )
 
@@ -25,6 +26,15 @@ var LONLAT *GridDef = &GridDef{0, 0, 180, 90, true, true}
 /* equatorial coordinate system */
 var STELLAR *GridDef = &GridDef{12, 0, 12, 90, false, true}
 
+/* longitude and latitude in degrees of any frame, see Frame */
+var DEGREES *GridDef = &GridDef{180, 0, 180, 90, false, true}
+
+/* galactic coordinates in degrees, see GalacticFrame */
+var GALACTIC *GridDef = &GridDef{180, 0, 180, 90, false, true}
+
+/* ecliptic coordinates in degrees, see EclipticFrame */
+var ECLIPTIC *GridDef = &GridDef{180, 0, 180, 90, false, true}
+
 /* interface for getting geohash strings */
 type GeoHasher interface {
 	/*
@@ -229,3 +239,114 @@ func BBoxHash(lower, upper *Point, gd *GridDef) (string, string) {
 	}
 	return minhash, minbuff.String()
 }
+
+/* bounds of a geohash cell, in the same direction as the hash bits */
+type hashCell struct {
+	minx, maxx, miny, maxy float64
+}
+
+/* takes in the hash character index and a character value
+returns the cell that character selects inside of this cell */
+func (c hashCell) child(index int, val byte) hashCell {
+	for j := 0; j < 5; j += 1 {
+		set := val&(0x10>>uint(j)) != 0
+		if (index*5+j)%2 == 0 {
+			mid := (c.minx + c.maxx) / 2
+			if set {
+				c.minx = mid
+			} else {
+				c.maxx = mid
+			}
+		} else {
+			mid := (c.miny + c.maxy) / 2
+			if set {
+				c.miny = mid
+			} else {
+				c.maxy = mid
+			}
+		}
+	}
+	return c
+}
+
+/*
+takes in bounds, the maximum number of geohash characters and a grid
+returns sorted, non overlapping geohash query ranges that cover bounds.
+unlike BBoxHash() cells that only partly overlap the bounds are split until
+precision is reached, so ranges include fewer points outside of bounds
+*/
+func BBoxCover(lower, upper *Point, precision int, gd *GridDef) []HashRange {
+	lowerx, upperx := lower.X(), upper.X()
+	lowery, uppery := lower.Y(), upper.Y()
+	xcenter, ycenter := gd.xcenter, gd.ycenter
+	if !gd.xIncreasesRight {
+		xcenter = -xcenter
+		lowerx = -lowerx
+		upperx = -upperx
+	}
+	if !gd.yIncreasesUp {
+		ycenter = -ycenter
+		lowery = -lowery
+		uppery = -uppery
+	}
+	bounds := hashCell{math.Min(lowerx, upperx), math.Max(lowerx, upperx),
+		math.Min(lowery, uppery), math.Max(lowery, uppery)}
+	grid := hashCell{xcenter - gd.xoffset, xcenter + gd.xoffset,
+		ycenter - gd.yoffset, ycenter + gd.yoffset}
+	if precision < 1 {
+		precision = 1
+	}
+	rval := make([]HashRange, 0, 8)
+	return coverCell(rval, "", grid, bounds, precision)
+}
+
+/* recursively adds ranges for children of cell with prefix that overlap
+bounds to ranges */
+func coverCell(ranges []HashRange, prefix string, cell, bounds hashCell,
+	precision int) []HashRange {
+	index := len(prefix)
+	for i := 0; i < len(BASE32); i += 1 {
+		c := cell.child(index, byte(i))
+		if c.maxx < bounds.minx || c.minx > bounds.maxx ||
+			c.maxy < bounds.miny || c.miny > bounds.maxy {
+			continue
+		}
+		hash := prefix + BASE32[i:i+1]
+		inside := c.minx >= bounds.minx && c.maxx <= bounds.maxx &&
+			c.miny >= bounds.miny && c.maxy <= bounds.maxy
+		if inside || index+1 >= precision {
+			ranges = addRange(ranges, hash)
+		} else {
+			ranges = coverCell(ranges, hash, c, bounds, precision)
+		}
+	}
+	return ranges
+}
+
+/* adds range of all hashes starting with prefix to ranges,
+merging with the last range if they are adjacent */
+func addRange(ranges []HashRange, prefix string) []HashRange {
+	/* trailing zeros don't change where the range starts */
+	min := strings.TrimRight(prefix, "0")
+	max := nextPrefix(prefix)
+	last := len(ranges) - 1
+	if last >= 0 && ranges[last].Max == min {
+		ranges[last].Max = max
+	} else {
+		ranges = append(ranges, HashRange{min, max})
+	}
+	return ranges
+}
+
+/* returns the smallest hash prefix that sorts after all hashes
+starting with prefix */
+func nextPrefix(prefix string) string {
+	for i := len(prefix) - 1; i >= 0; i -= 1 {
+		index := strings.IndexByte(BASE32, prefix[i])
+		if index+1 < len(BASE32) {
+			return prefix[:i] + BASE32[index+1:index+2]
+		}
+	}
+	/* tilde used as max value when we walk off base32 */
+	return "~"
+}
@@ -187,6 +187,29 @@ func (p *Polygon) Coords() *CoordinateSeq {
 	return &p.c
 }
 
+/*
+returns the planar centroid of the first two dimensions of this closed
+polygon and its area, area is negative for clockwise polygons.
+the average of the vertices is returned with zero area if the polygon
+has no area
+*/
+func (p *Polygon) Centroid() (*Point, float64) {
+	var area, cx, cy, sumx, sumy float64
+	n := p.c.Len()
+	for i := 0; i < n; i += 1 {
+		c0, c1 := p.c.Get(i), p.c.Get((i+1)%n)
+		cross := c0[0]*c1[1] - c1[0]*c0[1]
+		area += cross
+		cx += (c0[0] + c1[0]) * cross
+		cy += (c0[1] + c1[1]) * cross
+		sumx, sumy = sumx+c0[0], sumy+c0[1]
+	}
+	if area == 0 {
+		return NewPoint2D(sumx/float64(n), sumy/float64(n)), 0
+	}
+	return NewPoint2D(cx/(3*area), cy/(3*area)), area / 2
+}
+
 /* return true if a is between the b's */
 func between(a, b0, b1 float64) bool {
 	return (b0 > a) != (b1 > a)
@@ -285,6 +308,16 @@ func NewBBox3D(x0, y0, z0, x1, y1, z1 float64) *BoundingBox {
 	return &BoundingBox{min, max}
 }
 
+/* returns the lower bounds point */
+func (bb *BoundingBox) Lower() *Point {
+	return &Point{bb.min}
+}
+
+/* returns the upper bounds point */
+func (bb *BoundingBox) Upper() *Point {
+	return &Point{bb.max}
+}
+
 /* return true if other bounding box has same points as this */
 func (bb *BoundingBox) Equals(other *BoundingBox) bool {
 	return equals(bb.min, other.min) && equals(bb.max, other.max)
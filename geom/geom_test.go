@@ -5,6 +5,7 @@ import (
 	"math"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestBBoxPoint(t *testing.T) {
@@ -208,3 +209,446 @@ func TestInPoly(t *testing.T) {
 		}
 	}
 }
+
+func TestAngularSeparation(t *testing.T) {
+	/* along the equator an hour is 15 degrees */
+	assertAngle(t, AngularSeparation(0, 0, 1, 0), 15)
+	/* across the 0h/24h seam */
+	assertAngle(t, AngularSeparation(23.5, 0, 0.5, 0), 15)
+	assertAngle(t, AngularSeparation(0, 10, 24, 10), 0)
+	/* right ascension doesn't matter at the poles */
+	assertAngle(t, AngularSeparation(3, 90, 15, 90), 0)
+	assertAngle(t, AngularSeparation(0, 89, 12, 89), 2)
+	assertAngle(t, AngularSeparation(6, -90, 6, 90), 180)
+	/* an hour near the pole is much less than 15 degrees */
+	p0 := NewPoint2D(0, 80)
+	p1 := NewPoint2D(1, 80)
+	assertAngle(t, p0.AngularDistance(p1), 2.5975)
+	/* antipodal points */
+	assertAngle(t, AngularSeparation(0, 0, 12, 0), 180)
+}
+
+func TestRAHalfWidth(t *testing.T) {
+	assertAngle(t, RAHalfWidth(NewPoint2D(12, 0), 15), 1)
+	assertAngle(t, RAHalfWidth(NewPoint2D(12, 85), 6), 12)
+	assertAngle(t, RAHalfWidth(NewPoint2D(12, -60), 1), 2.0/15)
+}
+
+func assertAngle(t *testing.T, res, exp float64) {
+	if math.Abs(res-exp) > 0.0001 {
+		t.Errorf("Expected %v, got %v", exp, res)
+	}
+}
+
+func TestSplitBBox(t *testing.T) {
+	/* centered on 0h */
+	parts := SplitBBox2D(1, -10, -1, 10, STELLAR)
+	assertParts(t, parts, []float64{23, 24, 0, 1}, []float64{-24, 0})
+	parts = SplitBBox2D(23, -10, 25, 10, STELLAR)
+	assertParts(t, parts, []float64{23, 24, 0, 1}, []float64{0, 24})
+	/* full sky doesn't wrap */
+	parts = SplitBBox2D(24, -90, 0, 90, STELLAR)
+	assertParts(t, parts, []float64{0, 24}, []float64{0})
+	parts = SplitBBox2D(-24, -90, 0, 90, STELLAR)
+	assertParts(t, parts, []float64{0, 24}, []float64{-24})
+	parts = SplitBBox2D(170, 0, 190, 10, LONLAT)
+	assertParts(t, parts, []float64{170, 180, -180, -170}, []float64{0, 360})
+
+	ranges := SplitBBoxHash(NewPoint2D(1.5, -90), NewPoint2D(-1.5, -67.5),
+		STELLAR)
+	if len(ranges) != 2 {
+		t.Fatalf("expected 2 hash ranges, got %v", ranges)
+	}
+	/* 22.5h to 24h is the first cell in stellar coordinates */
+	if ranges[0].Min != "00" || ranges[0].Max != "08" {
+		t.Errorf("expected 00-08, got %v", ranges[0])
+	}
+}
+
+func assertParts(t *testing.T, parts []*BBoxPart, xs, shifts []float64) {
+	if len(parts) != len(shifts) {
+		t.Fatalf("expected %v parts, got %v", len(shifts), len(parts))
+	}
+	for i, part := range parts {
+		if part.Lower().X() != xs[i*2] || part.Upper().X() != xs[i*2+1] ||
+			part.Shift != shifts[i] {
+			t.Errorf("expected %v-%v shifted %v, got %v-%v shifted %v",
+				xs[i*2], xs[i*2+1], shifts[i], part.Lower().X(),
+				part.Upper().X(), part.Shift)
+		}
+	}
+}
+
+func TestWrapX(t *testing.T) {
+	assertAngle(t, STELLAR.WrapX(-1), 23)
+	assertAngle(t, STELLAR.WrapX(25.5), 1.5)
+	assertAngle(t, STELLAR.WrapX(12), 12)
+	assertAngle(t, LONLAT.WrapX(190), -170)
+}
+
+func TestBBoxCover(t *testing.T) {
+	ranges := BBoxCover(NewPoint2D(24, -90), NewPoint2D(0, 90), 3, STELLAR)
+	if len(ranges) != 1 || ranges[0].Min != "" || ranges[0].Max != "~" {
+		t.Errorf("expected whole grid, got %v", ranges)
+	}
+	/* straddles both major splits, single range is the whole grid */
+	lower, upper := NewPoint2D(12.5, -1), NewPoint2D(11.5, 1)
+	min, max := BBoxHash(lower, upper, STELLAR)
+	if min != "0" || max != "~" {
+		t.Errorf("expected 0-~, got %v-%v", min, max)
+	}
+	ranges = BBoxCover(lower, upper, 3, STELLAR)
+	if len(ranges) == 0 || len(ranges) > 16 {
+		t.Fatalf("expected a few ranges, got %v", ranges)
+	}
+	for i := 1; i < len(ranges); i += 1 {
+		if ranges[i].Min < ranges[i-1].Max {
+			t.Errorf("ranges out of order: %v", ranges)
+		}
+	}
+	for x := 11.5; x <= 12.5; x += 0.05 {
+		for y := -1.0; y <= 1; y += 0.1 {
+			hash := NewPoint2D(x, y).GeoHash(STELLAR)
+			if !inRanges(hash, ranges) {
+				t.Errorf("%v,%v (%v) not covered by %v", x, y, hash, ranges)
+			}
+		}
+	}
+	if hash := NewPoint2D(6, 45).GeoHash(STELLAR); inRanges(hash, ranges) {
+		t.Errorf("%v shouldn't be covered by %v", hash, ranges)
+	}
+}
+
+func inRanges(hash string, ranges []HashRange) bool {
+	for _, r := range ranges {
+		if hash >= r.Min && hash < r.Max {
+			return true
+		}
+	}
+	return false
+}
+
+func TestNearestX(t *testing.T) {
+	assertAngle(t, STELLAR.NearestX(23.5, 0.5), -0.5)
+	assertAngle(t, STELLAR.NearestX(0.5, 23.5), 24.5)
+	assertAngle(t, STELLAR.NearestX(6, 5), 6)
+	assertAngle(t, LONLAT.NearestX(-175, 170), 185)
+}
+
+func TestProjections(t *testing.T) {
+	projs := map[string]Projection{
+		"stereographic":         NewStereographic(30, 40),
+		"orthographic":          NewOrthographic(30, 40),
+		"gnomonic":              NewGnomonic(30, 40),
+		"azimuthal-equidistant": NewAzimuthalEquidistant(30, 40),
+		"mollweide":             NewMollweide(30, 40),
+		"hammer-aitoff":         NewHammerAitoff(30, 40),
+	}
+	points := [][2]float64{{30, 40}, {45, 50}, {10, 20}, {60, 80}, {0, 0}}
+	for name, proj := range projs {
+		for _, p := range points {
+			x, y, ok := proj.Forward(p[0], p[1])
+			if !ok {
+				t.Errorf("%v: %v should be visible", name, p)
+				continue
+			}
+			lon, lat, ok := proj.Inverse(x, y)
+			if !ok || math.Abs(lon-p[0]) > 1e-6 || math.Abs(lat-p[1]) > 1e-6 {
+				t.Errorf("%v: expected %v, got %v %v %v", name, p, lon, lat, ok)
+			}
+		}
+		/* center of the projection maps to the origin */
+		if x, y, _ := proj.Forward(30, 40); name != "mollweide" &&
+			name != "hammer-aitoff" && (math.Abs(x) > 1e-9 ||
+			math.Abs(y) > 1e-9) {
+			t.Errorf("%v: expected center at origin, got %v %v", name, x, y)
+		}
+	}
+	/* back of the sphere */
+	if _, _, ok := projs["orthographic"].Forward(210, -40); ok {
+		t.Errorf("orthographic shouldn't show the far hemisphere")
+	}
+	if _, _, ok := projs["gnomonic"].Forward(120, 0); ok {
+		t.Errorf("gnomonic shouldn't show 90 degrees from center")
+	}
+	if _, _, ok := projs["mollweide"].Inverse(3, 0); ok {
+		t.Errorf("mollweide inverse should reject points outside ellipse")
+	}
+}
+
+func TestProjectedTransform(t *testing.T) {
+	lower, upper := NewPoint2D(24, 60), NewPoint2D(0, 90)
+	trans := CreateProjectedTransform(lower, upper, 256, 256, STELLAR,
+		NewStereographic)
+	/* polar cap is centered on the pole */
+	pole := trans.TransformXY(3, 90)
+	if pole.X < 127 || pole.X > 129 || pole.Y < 127 || pole.Y > 129 {
+		t.Errorf("expected pole near center, got %v", pole)
+	}
+	p := NewPoint2D(2.5, 75)
+	rev := trans.Reverse(trans.Transform(p))
+	if rev == nil || AngularSeparation(p.X(), p.Y(), rev.X(), rev.Y()) >
+		2*trans.Dy {
+		t.Errorf("expected %v, got %v", p, rev)
+	}
+	/* facing north with 0h toward the zenith, 6h is east on the right */
+	if trans.TransformXY(6, 75).X < trans.TransformXY(18, 75).X {
+		t.Errorf("expected 6h right of 18h")
+	}
+	bbox := trans.Bounds()
+	if bbox.Upper().Y() != 90 || bbox.Upper().X()-bbox.Lower().X() < 24 {
+		t.Errorf("expected bounds to include pole, got %v-%v", bbox.Lower(),
+			bbox.Upper())
+	}
+	/* right ascension increases to the left away from poles */
+	trans = CreateProjectedTransform(NewPoint2D(13, 0), NewPoint2D(11, 10),
+		256, 256, STELLAR, NewMollweide)
+	if trans.TransformXY(12.5, 5).X > trans.TransformXY(11.5, 5).X {
+		t.Errorf("expected 12.5h left of 11.5h")
+	}
+	seg := trans.Densify([]float64{0, 60}, []float64{6, 60}, 1)
+	if len(seg) != 91 {
+		t.Errorf("expected 91 points, got %v", len(seg))
+	}
+}
+
+func TestFrames(t *testing.T) {
+	/* galactic north pole and center */
+	_, b := GalacticFrame.FromEquatorial(192.85948, 27.12825)
+	assertAngle(t, b, 90)
+	l, b := GalacticFrame.FromEquatorial(266.40499, -28.93617)
+	if l > 180 {
+		l -= 360
+	}
+	assertAngle(t, l, 0)
+	assertAngle(t, b, 0)
+	/* summer solstice */
+	lon, lat := EclipticFrame.FromEquatorial(90, Obliquity)
+	assertAngle(t, lon, 90)
+	assertAngle(t, lat, 0)
+	for _, f := range []*Frame{GalacticFrame, EclipticFrame} {
+		ra, dec := f.ToEquatorial(f.FromEquatorial(37.5, -42.25))
+		assertAngle(t, ra, 37.5)
+		assertAngle(t, dec, -42.25)
+	}
+}
+
+func TestPointFrames(t *testing.T) {
+	/* galactic center */
+	p := NewPoint2D(17.760333, -28.93617).ToGalactic()
+	assertAngle(t, GALACTIC.NearestX(p.X(), 0), 0)
+	assertAngle(t, p.Y(), 0)
+	eq := NewPoint2D(0, 90).FromGalactic()
+	assertAngle(t, eq.X(), 12.857299)
+	assertAngle(t, eq.Y(), 27.12825)
+	/* vernal equinox */
+	p = NewPoint2D(0, 0).ToEcliptic()
+	assertAngle(t, p.X(), 0)
+	assertAngle(t, p.Y(), 0)
+	eq = NewPoint2D(90, 0).FromEcliptic()
+	assertAngle(t, eq.X(), 6)
+	assertAngle(t, eq.Y(), Obliquity)
+	/* galactic points hash like any other grid */
+	p = NewPoint2D(283.75, -12.5)
+	back, err := UnHash(p.GeoHash(GALACTIC), GALACTIC)
+	if err != nil {
+		t.Fatalf("can't unhash galactic point: %v", err)
+	}
+	assertAngle(t, back.X(), 283.75)
+	assertAngle(t, back.Y(), -12.5)
+}
+
+func TestEquatorialBounds(t *testing.T) {
+	boxes := []*BoundingBox{
+		NewBBox2D(-5, -5, 5, 5),
+		NewBBox2D(120, 20, 130, 30),
+		NewBBox2D(200, -60, 260, -30),
+	}
+	for _, box := range boxes {
+		eq := EquatorialBounds(GalacticFrame, box)
+		for x := box.Lower().X(); x <= box.Upper().X(); x += 0.5 {
+			for y := box.Lower().Y(); y <= box.Upper().Y(); y += 0.5 {
+				p := NewPoint2D(x, y).FromGalactic()
+				ra := STELLAR.NearestX(p.X(),
+					(eq.Lower().X()+eq.Upper().X())/2)
+				if ra < eq.Lower().X() || ra > eq.Upper().X() ||
+					p.Y() < eq.Lower().Y() || p.Y() > eq.Upper().Y() {
+					t.Errorf("%v, %v outside of %v for %v", ra, p.Y(), eq, box)
+				}
+			}
+		}
+	}
+}
+
+func TestPrecession(t *testing.T) {
+	/* aquila boundary vertex, 18h35m -3 degrees in B1875 */
+	p := NewPoint2D(18.6924114, -2.8842952).Precess(J2000, B1875)
+	assertAngle(t, p.X(), 18.583333)
+	assertAngle(t, p.Y(), -3)
+	/* about 3.07 seconds of right ascension and 20 arc seconds of
+	declination each year at the equinox */
+	p = NewPoint2D(0, 0).Precess(B1950, J2000)
+	if math.Abs(p.X()-0.0427) > 0.0001 || math.Abs(p.Y()-0.2784) > 0.0001 {
+		t.Errorf("unexpected B1950 to J2000 precession %v", p)
+	}
+	/* right ascension stays continuous across 0h */
+	p = NewPoint2D(0, 0).Precess(J2000, B1950)
+	if p.X() > 0 || p.X() < -0.05 {
+		t.Errorf("expected right ascension just before 0h, got %v", p)
+	}
+	back := p.Precess(B1950, J2000)
+	assertAngle(t, back.X(), 0)
+	assertAngle(t, back.Y(), 0)
+	/* vertices on the grid edge stay there */
+	cs := &CoordinateSeq{[]float64{24, 10, 23.5, 20, 0, 90}, 2}
+	res := cs.Precess(J2000, B1875)
+	if res.Get(0)[0] != 24 || res.Get(0)[1] == 10 {
+		t.Errorf("expected edge vertex to stay on edge, got %v", res)
+	}
+	if res.Get(1)[0] == 23.5 {
+		t.Errorf("expected vertex to be precessed, got %v", res)
+	}
+	if res.Get(2)[0] != 0 || res.Get(2)[1] != 90 {
+		t.Errorf("expected pole to be unchanged, got %v", res)
+	}
+	if cs.Get(1)[0] != 23.5 {
+		t.Errorf("precession modified original sequence %v", cs)
+	}
+}
+
+func TestSun(t *testing.T) {
+	j2000 := time.Date(2000, time.January, 1, 12, 0, 0, 0, time.UTC)
+	assertAngle(t, JulianDate(j2000), J2000)
+	/* june solstice in 2010 */
+	solstice := time.Date(2010, time.June, 21, 11, 28, 0, 0, time.UTC)
+	lon := SunLongitude(JulianDate(solstice))
+	if math.Abs(lon-90) > 0.02 {
+		t.Errorf("expected sun at 90 degrees, got %v", lon)
+	}
+}
+
+func TestHorizon(t *testing.T) {
+	/* meeus, astronomical algorithms, examples 12.a and 12.b */
+	midnight := time.Date(1987, time.April, 10, 0, 0, 0, 0, time.UTC)
+	assertAngle(t, GreenwichSiderealTime(JulianDate(midnight)), 197.693195)
+	evening := time.Date(1987, time.April, 10, 19, 21, 0, 0, time.UTC)
+	assertAngle(t, GreenwichSiderealTime(JulianDate(evening)), 128.737873)
+	assertAngle(t, LocalSiderealTime(JulianDate(evening), -128.737873), 0)
+	frame := HorizonFrame(J2000, 40, -75)
+	if !frame.Mirrored() || GalacticFrame.Mirrored() {
+		t.Errorf("expected only the horizon frame to be mirrored")
+	}
+	/* the celestial pole is north at the observer's latitude, the equator
+	on the meridian is south */
+	lst := LocalSiderealTime(J2000, -75)
+	for _, c := range [][4]float64{{0, 90, 0, 40}, {lst, 0, 180, 50},
+		{lst + 90, 0, 90, 0}} {
+		az, alt := frame.FromEquatorial(c[0], c[1])
+		if math.Abs(alt-c[3]) > 0.01 || math.Abs(az-c[2]) > 0.01 {
+			t.Errorf("expected %v at %v,%v got %v,%v", c[:2], c[2], c[3], az,
+				alt)
+		}
+	}
+}
+
+func TestCentroid(t *testing.T) {
+	square, _ := NewPoly2D(0, 0, 0, 2, 2, 2, 2, 0, 0, 0)
+	center, area := square.Centroid()
+	assertAngle(t, center.X(), 1)
+	assertAngle(t, center.Y(), 1)
+	/* clockwise */
+	assertAngle(t, area, -4)
+	line, _ := NewPoly2D(0, 0, 2, 2, 0, 0)
+	center, area = line.Centroid()
+	if area != 0 || center.X() <= 0 || center.Y() <= 0 {
+		t.Errorf("expected vertex average, got %v %v", center, area)
+	}
+}
+
+func TestRefraction(t *testing.T) {
+	air := StandardAtmosphere
+	/* about 29 and 34 arc minutes at the horizon */
+	if r := air.Refraction(0); math.Abs(r-0.483) > 0.005 {
+		t.Errorf("expected about 0.48 degrees, got %v", r)
+	}
+	if alt := air.TrueAltitude(0); math.Abs(alt+0.575) > 0.005 {
+		t.Errorf("expected about -0.58 degrees, got %v", alt)
+	}
+	for _, alt := range []float64{-3, -0.5, 0, 10, 45, 89} {
+		if res := air.TrueAltitude(air.Apparent(alt)); math.Abs(res-alt) >
+			0.01 {
+			t.Errorf("expected %v back, got %v", alt, res)
+		}
+	}
+	/* thin cold air bends less than thick */
+	thin := &Atmosphere{Temperature: 10, Pressure: 505}
+	assertAngle(t, thin.Refraction(10), air.Refraction(10)/2)
+	none := &Atmosphere{Temperature: 10, Pressure: 0}
+	assertAngle(t, none.Apparent(1), 1)
+	assertAngle(t, none.Extinction(1), 0)
+	if x := Airmass(90); math.Abs(x-1) > 0.001 {
+		t.Errorf("expected airmass 1 at the zenith, got %v", x)
+	}
+	if x := Airmass(0); math.Abs(x-38) > 0.2 {
+		t.Errorf("expected airmass about 38 at the horizon, got %v", x)
+	}
+	if e := air.Extinction(10) - air.Extinction(90); e < 0.8 || e > 1.2 {
+		t.Errorf("expected about a magnitude of extinction at 10 degrees,"+
+			" got %v", e)
+	}
+}
+
+func TestPlanets(t *testing.T) {
+	/* meeus, astronomical algorithms, examples 25.b, 32.a and 47.a */
+	l, b, r := vsopEarth.position(2448908.5)
+	assertAngle(t, ECLIPTIC.WrapX(toDegrees(l)), 19.907372)
+	assertAngle(t, r, 0.997608)
+	l, b, r = vsopVenus.position(2448976.5)
+	assertAngle(t, ECLIPTIC.WrapX(toDegrees(l)), 26.11428)
+	assertAngle(t, toDegrees(b), -2.62070)
+	assertAngle(t, r, 0.724603)
+	lon, lat, km := MoonPosition(2448724.5)
+	assertAngle(t, lon, 133.162655)
+	assertAngle(t, lat, -3.229126)
+	if math.Abs(km-368409.7) > 0.1 {
+		t.Errorf("expected 368409.7 km, got %v", km)
+	}
+	/* jupiter at opposition on 2023-11-03, 1.4 degrees south of the
+	ecliptic */
+	opposition := time.Date(2023, time.November, 3, 5, 0, 0, 0, time.UTC)
+	jupiter := NewEphemeris(Jupiter, JulianDate(opposition))
+	if jupiter.Elongation < 178.5 || jupiter.Phase < 0.9999 {
+		t.Errorf("expected jupiter opposite the sun, got %v", jupiter)
+	}
+	if math.Abs(jupiter.Magnitude+2.9) > 0.1 ||
+		math.Abs(jupiter.Distance-3.98) > 0.01 {
+		t.Errorf("expected magnitude -2.9 at 3.98 AU, got %v", jupiter)
+	}
+	sun := NewEphemeris(Sun, JulianDate(opposition))
+	d := sun.Position.AngularDistance(jupiter.Position)
+	if math.Abs(d-jupiter.Elongation) > 0.01 {
+		t.Errorf("expected %v degrees from the sun, got %v",
+			jupiter.Elongation, d)
+	}
+	if math.Abs(sun.Radius-0.2688) > 0.001 {
+		t.Errorf("expected the sun about 16 arc minutes across, got %v",
+			sun.Radius)
+	}
+	/* new and full moons of january 2024 */
+	newMoon := time.Date(2024, time.January, 11, 11, 57, 0, 0, time.UTC)
+	fullMoon := time.Date(2024, time.January, 25, 17, 54, 0, 0, time.UTC)
+	if m := NewEphemeris(Moon, JulianDate(newMoon)); m.Phase > 0.01 {
+		t.Errorf("expected new moon, got %v", m.Phase)
+	}
+	m := NewEphemeris(Moon, JulianDate(fullMoon))
+	if m.Phase < 0.99 || m.Magnitude > -12.5 || m.Elongation < 175 {
+		t.Errorf("expected full moon, got %v", m)
+	}
+	bodies := SolarSystem(JulianDate(fullMoon))
+	if len(bodies) != len(Bodies) || bodies[Saturn].Body != Saturn {
+		t.Errorf("expected every body in order, got %v", bodies)
+	}
+	if dt := DeltaT(J2000); math.Abs(dt-63.86) > 0.01 {
+		t.Errorf("expected 63.86 seconds, got %v", dt)
+	}
+}
@@ -0,0 +1,61 @@
+package geom
+
+import (
+	"math"
+)
+
+/*
+takes in julian date of universal time
+returns greenwich mean sidereal time in degrees [0, 360)
+using the IAU 1982 expression
+*/
+func GreenwichSiderealTime(jd float64) float64 {
+	d := jd - J2000
+	t := d / julianCentury
+	rval := 280.46061837 + 360.98564736629*d + t*t*(0.000387933-t/38710000)
+	rval = math.Mod(rval, 360)
+	if rval < 0 {
+		rval += 360
+	}
+	return rval
+}
+
+/*
+takes in julian date of universal time and longitude in degrees,
+positive east
+returns local mean sidereal time in degrees [0, 360). this is the right
+ascension on the meridian
+*/
+func LocalSiderealTime(jd, lon float64) float64 {
+	rval := math.Mod(GreenwichSiderealTime(jd)+lon, 360)
+	if rval < 0 {
+		rval += 360
+	}
+	return rval
+}
+
+/*
+takes in julian date of universal time and observer latitude and longitude
+in degrees, longitude positive east
+returns frame of azimuth, degrees east of north, and altitude above the
+horizon. J2000 coordinates are precessed to the date, nutation and
+aberration are ignored. the frame is mirrored, see Frame.Mirrored()
+*/
+func HorizonFrame(jd, lat, lon float64) *Frame {
+	sinLST, cosLST := math.Sincos(toRadians(LocalSiderealTime(jd, lon)))
+	sinLat, cosLat := math.Sincos(toRadians(lat))
+	/* turn the meridian to the x axis then tip the pole down to the north
+	point of the horizon, leaving the zenith on the z axis */
+	meridian := [3][3]float64{
+		{cosLST, sinLST, 0},
+		{-sinLST, cosLST, 0},
+		{0, 0, 1},
+	}
+	horizon := [3][3]float64{
+		{-sinLat, 0, cosLat},
+		{0, 1, 0},
+		{cosLat, 0, sinLat},
+	}
+	m := multiply(horizon, multiply(meridian, precessionMatrix(jd)))
+	return &Frame{m}
+}
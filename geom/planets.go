@@ -0,0 +1,291 @@
+package geom
+
+import (
+	"math"
+)
+
+/* sun, moon or planet */
+type Body int
+
+/* bodies in the order they are drawn */
+const (
+	Sun Body = iota
+	Moon
+	Mercury
+	Venus
+	Mars
+	Jupiter
+	Saturn
+	Uranus
+	Neptune
+)
+
+/* every body, see SolarSystem() */
+var Bodies = []Body{Sun, Moon, Mercury, Venus, Mars, Jupiter, Saturn, Uranus,
+	Neptune}
+
+var bodyNames = []string{"Sun", "Moon", "Mercury", "Venus", "Mars",
+	"Jupiter", "Saturn", "Uranus", "Neptune"}
+
+/* see fmt.Stringer */
+func (b Body) String() string {
+	return bodyNames[b]
+}
+
+/* series for each planet, nil for the sun and moon */
+var bodySeries = []*vsopPlanet{nil, nil, vsopMercury, vsopVenus, vsopMars,
+	vsopJupiter, vsopSaturn, vsopUranus, vsopNeptune}
+
+/* equatorial radius of each body in km */
+var bodyRadii = []float64{696000, 1737.4, 2439.7, 6051.8, 3396.2, 71492,
+	60268, 25559, 24764}
+
+/* km in an astronomical unit */
+const KmPerAU = 149597870.7
+
+/* days light takes to travel one AU */
+const lightDaysPerAU = 0.0057755183
+
+/* constant of aberration in degrees */
+const aberration = 20.49552 / 3600
+
+/* apparent place and look of a body seen from the center of the earth */
+type Ephemeris struct {
+	Body Body
+	/* right ascension in hours and declination in degrees for the J2000
+	mean equinox, like the star catalog */
+	Position *Point
+	/* AU from the earth */
+	Distance float64
+	/* AU from the sun, zero for the sun */
+	SunDistance float64
+	/* degrees between the body and the sun seen from the earth */
+	Elongation float64
+	/* degrees between the sun and the earth seen from the body */
+	PhaseAngle float64
+	/* fraction of the disk that is lit */
+	Phase     float64
+	Magnitude float64
+	/* apparent radius of the disk in degrees */
+	Radius float64
+}
+
+/*
+takes in julian date of universal time
+returns difference between terrestrial time and universal time in seconds
+from the Espenak and Meeus polynomials, good to a few seconds since 1961
+and growing less certain further from the present
+*/
+func DeltaT(jd float64) float64 {
+	y := 2000 + (jd-J2000)/365.25
+	u := (y - 1820) / 100
+	switch {
+	case y >= 1961 && y < 1986:
+		t := y - 1975
+		return 45.45 + t*(1.067+t*(-1.0/260+t*-1.0/718))
+	case y >= 1986 && y < 2005:
+		t := y - 2000
+		return 63.86 + t*(0.3345+t*(-0.060374+t*(0.0017275+
+			t*(0.000651814+t*0.00002373599))))
+	case y >= 2005 && y < 2050:
+		t := y - 2000
+		return 62.92 + t*(0.32217+t*0.005589)
+	case y >= 2050 && y < 2150:
+		return -20 + 32*u*u - 0.5628*(2150-y)
+	}
+	return -20 + 32*u*u
+}
+
+/* takes in julian date returns mean obliquity of the ecliptic in degrees */
+func meanObliquity(jd float64) float64 {
+	t := (jd - J2000) / julianCentury
+	return Obliquity + t*(-46.8150+t*(-0.00059+t*0.001813))/3600
+}
+
+/* takes in julian date returns frame of ecliptic coordinates for the mean
+equinox of date */
+func eclipticOfDate(jd float64) *Frame {
+	ecliptic := newEclipticFrame(meanObliquity(jd))
+	return &Frame{multiply(ecliptic.m, precessionMatrix(jd))}
+}
+
+/* takes in series and julian millennia from J2000 returns sum of series */
+func vsopSum(series [][]vsopTerm, tau float64) float64 {
+	var rval float64
+	power := 1.0
+	for _, terms := range series {
+		var sum float64
+		for _, term := range terms {
+			sum += term[0] * math.Cos(term[1]+term[2]*tau)
+		}
+		rval += sum * power
+		power *= tau
+	}
+	return rval / 1e8
+}
+
+/*
+takes in julian ephemeris date
+returns heliocentric ecliptic longitude and latitude in radians and
+radius in AU for the mean equinox of date
+*/
+func (p *vsopPlanet) position(jde float64) (float64, float64, float64) {
+	tau := (jde - J2000) / (10 * julianCentury)
+	return vsopSum(p.l, tau), vsopSum(p.b, tau), vsopSum(p.r, tau)
+}
+
+/* takes in ecliptic longitude, latitude in radians and radius
+returns rectangular coordinates */
+func toRect(l, b, r float64) [3]float64 {
+	sinL, cosL := math.Sincos(l)
+	sinB, cosB := math.Sincos(b)
+	return [3]float64{r * cosB * cosL, r * cosB * sinL, r * sinB}
+}
+
+/* takes in a vector returns its length */
+func length(v [3]float64) float64 {
+	return math.Sqrt(v[0]*v[0] + v[1]*v[1] + v[2]*v[2])
+}
+
+/* takes in sides of a triangle returns angle in degrees opposite of c */
+func triangleAngle(a, b, c float64) float64 {
+	cos := (a*a + b*b - c*c) / (2 * a * b)
+	return toDegrees(math.Acos(math.Max(math.Min(cos, 1), -1)))
+}
+
+/*
+takes in body and julian date of universal time
+returns its apparent place, corrected for the time light takes to reach
+the earth and for aberration. nutation is left out to match the mean
+equinox of the star catalog
+*/
+func NewEphemeris(b Body, jd float64) *Ephemeris {
+	jde := jd + DeltaT(jd)/86400
+	el, eb, er := vsopEarth.position(jde)
+	earth := toRect(el, eb, er)
+	rval := &Ephemeris{Body: b}
+	var lon, lat float64
+	switch b {
+	case Sun:
+		/* light time and aberration together */
+		lon = toDegrees(el) + 180 - aberration/er
+		lat = -toDegrees(eb)
+		rval.Distance = er
+		rval.Magnitude = -26.74 + 5*math.Log10(er)
+		rval.Phase = 1
+	case Moon:
+		var km float64
+		lon, lat, km = MoonPosition(jde)
+		rval.Distance = km / KmPerAU
+		moon := toRect(toRadians(lon), toRadians(lat), rval.Distance)
+		var helio [3]float64
+		for i := 0; i < 3; i += 1 {
+			helio[i] = earth[i] + moon[i]
+		}
+		rval.SunDistance = length(helio)
+		rval.Elongation = triangleAngle(er, rval.Distance, rval.SunDistance)
+		rval.PhaseAngle = triangleAngle(rval.Distance, rval.SunDistance, er)
+		i := rval.PhaseAngle
+		/* full moon at its mean distance is -12.73 */
+		rval.Magnitude = -12.73 + 0.026*i + 4e-9*i*i*i*i +
+			5*math.Log10(km/moonMeanDistance)
+	default:
+		series := bodySeries[b]
+		var l, bb, r float64
+		var geo [3]float64
+		/* where the planet was when the light left it */
+		light := 0.0
+		for n := 0; n < 3; n += 1 {
+			l, bb, r = series.position(jde - light)
+			planet := toRect(l, bb, r)
+			for i := 0; i < 3; i += 1 {
+				geo[i] = planet[i] - earth[i]
+			}
+			light = length(geo) * lightDaysPerAU
+		}
+		rval.Distance = length(geo)
+		rval.SunDistance = r
+		lon = toDegrees(math.Atan2(geo[1], geo[0]))
+		lat = toDegrees(math.Atan2(geo[2], math.Hypot(geo[0], geo[1])))
+		/* annual aberration from the sun's longitude */
+		sinD, cosD := math.Sincos(toRadians(toDegrees(el) + 180 - lon))
+		sinLat, cosLat := math.Sincos(toRadians(lat))
+		lon -= aberration * cosD / cosLat
+		lat -= aberration * sinD * sinLat
+		rval.Elongation = triangleAngle(er, rval.Distance, r)
+		rval.PhaseAngle = triangleAngle(r, rval.Distance, er)
+		rval.Magnitude = planetMagnitude(b, r, rval.Distance,
+			rval.PhaseAngle, jde, l, bb, lon, lat)
+	}
+	if b != Sun {
+		rval.Phase = (1 + math.Cos(toRadians(rval.PhaseAngle))) / 2
+	}
+	rval.Radius = toDegrees(math.Asin(bodyRadii[b] /
+		(rval.Distance * KmPerAU)))
+	lon = ECLIPTIC.WrapX(lon)
+	rval.Position = NewPoint2D(lon, lat).FromFrame(eclipticOfDate(jde))
+	return rval
+}
+
+/* takes in julian date of universal time
+returns apparent places of every body in Bodies order */
+func SolarSystem(jd float64) []*Ephemeris {
+	rval := make([]*Ephemeris, len(Bodies))
+	for i, b := range Bodies {
+		rval[i] = NewEphemeris(b, jd)
+	}
+	return rval
+}
+
+/*
+takes in planet, AU from the sun and earth, phase angle in degrees,
+julian ephemeris date, heliocentric longitude and latitude in radians
+and geocentric longitude and latitude in degrees
+returns visual magnitude, Meeus, Astronomical Algorithms, chapter 41
+*/
+func planetMagnitude(b Body, r, delta, i, jde, l, bb, lon,
+	lat float64) float64 {
+	mag := 5 * math.Log10(r*delta)
+	switch b {
+	case Mercury:
+		return mag - 0.42 + i*(0.0380+i*(-0.000273+i*0.000002))
+	case Venus:
+		return mag - 4.40 + i*(0.0009+i*(0.000239+i*-0.00000065))
+	case Mars:
+		return mag - 1.52 + 0.016*i
+	case Jupiter:
+		return mag - 9.40 + 0.005*i
+	case Saturn:
+		sinB, du := saturnRings(jde, l, bb, lon, lat)
+		return mag - 8.88 + 0.044*du - 2.60*math.Abs(sinB) + 1.25*sinB*sinB
+	case Uranus:
+		return mag - 7.19
+	}
+	return mag - 6.87
+}
+
+/*
+takes in julian ephemeris date, saturn's heliocentric longitude and
+latitude in radians and geocentric longitude and latitude in degrees
+returns sine of the tilt of the rings toward the earth and the difference
+in degrees between the sun's and earth's longitude seen from saturn
+*/
+func saturnRings(jde, l, b, lon, lat float64) (float64, float64) {
+	t := (jde - J2000) / julianCentury
+	/* inclination and ascending node of the ring plane */
+	incl := toRadians(28.075216 + t*(-0.012998+t*0.000004))
+	node := toRadians(169.508470 + t*(1.394681+t*0.000412))
+	sinI, cosI := math.Sincos(incl)
+	lon, lat = toRadians(lon), toRadians(lat)
+	sinB := sinI*math.Cos(lat)*math.Sin(lon-node) - cosI*math.Sin(lat)
+	/* longitudes in the ring plane of the sun and earth */
+	u1 := math.Atan2(sinI*math.Sin(b)+cosI*math.Cos(b)*math.Sin(l-node),
+		math.Cos(b)*math.Cos(l-node))
+	u2 := math.Atan2(sinI*math.Sin(lat)+cosI*math.Cos(lat)*math.Sin(lon-node),
+		math.Cos(lat)*math.Cos(lon-node))
+	du := math.Abs(toDegrees(u1 - u2))
+	if du > 180 {
+		du = 360 - du
+	}
+	return sinB, du
+}
@@ -0,0 +1,146 @@
+package geom
+
+import (
+	"math"
+)
+
+/* julian date of the J2000.0 epoch */
+const J2000 = 2451545.0
+
+/* days in a julian century */
+const julianCentury = 36525.0
+
+/* days in a tropical year at B1900.0 */
+const besselianYear = 365.242198781
+
+/* julian date of the B1900.0 epoch */
+const b1900 = 2415020.31352
+
+/* epoch of the IAU constellation boundaries */
+var B1875 = BesselianEpoch(1875)
+
+/* epoch of the FK4 catalogue */
+var B1950 = BesselianEpoch(1950)
+
+/* takes in julian year such as 2000.0 returns julian date */
+func JulianEpoch(year float64) float64 {
+	return J2000 + (year-2000)*julianCentury/100
+}
+
+/* takes in besselian year such as 1875.0 returns julian date */
+func BesselianEpoch(year float64) float64 {
+	return b1900 + (year-1900)*besselianYear
+}
+
+/* takes in arc seconds returns radians */
+func arcsecToRadians(s float64) float64 {
+	return toRadians(s / 3600)
+}
+
+/*
+takes in julian date
+returns precession angles zeta, z and theta in radians from J2000 to date
+using the IAU 2006 polynomials. they agree with IAU 1976 to a few
+milliarcseconds over the last few centuries
+*/
+func precessionAngles(jd float64) (float64, float64, float64) {
+	t := (jd - J2000) / julianCentury
+	zeta := 2.650545 + t*(2306.083227+t*(0.2988499+t*(0.01801828+
+		t*(-0.000005971+t*-0.0000003173))))
+	z := -2.650545 + t*(2306.077181+t*(1.0927348+t*(0.01826837+
+		t*(-0.000028596+t*-0.0000002904))))
+	theta := t * (2004.191903 + t*(-0.4294934+t*(-0.04182264+
+		t*(-0.000007089+t*-0.0000001274))))
+	return arcsecToRadians(zeta), arcsecToRadians(z), arcsecToRadians(theta)
+}
+
+/* takes in julian date returns rotation from J2000 to mean equator of date */
+func precessionMatrix(jd float64) [3][3]float64 {
+	zeta, z, theta := precessionAngles(jd)
+	sinZeta, cosZeta := math.Sincos(zeta)
+	sinZ, cosZ := math.Sincos(z)
+	sinTheta, cosTheta := math.Sincos(theta)
+	return [3][3]float64{
+		{cosZeta*cosTheta*cosZ - sinZeta*sinZ,
+			-sinZeta*cosTheta*cosZ - cosZeta*sinZ, -sinTheta * cosZ},
+		{cosZeta*cosTheta*sinZ + sinZeta*cosZ,
+			-sinZeta*cosTheta*sinZ + cosZeta*cosZ, -sinTheta * sinZ},
+		{cosZeta * sinTheta, -sinZeta * sinTheta, cosTheta},
+	}
+}
+
+/*
+takes in julian dates of two epochs
+returns frame that rotates equatorial coordinates for the mean equinox of
+from into those for the mean equinox of to, see JulianEpoch()
+*/
+func Precession(from, to float64) *Frame {
+	a := precessionMatrix(to)
+	b := precessionMatrix(from)
+	var m [3][3]float64
+	/* rotate back to J2000 with the transpose of b then forward to date */
+	for i := 0; i < 3; i += 1 {
+		for j := 0; j < 3; j += 1 {
+			m[i][j] = a[i][0]*b[j][0] + a[i][1]*b[j][1] + a[i][2]*b[j][2]
+		}
+	}
+	return &Frame{m}
+}
+
+/*
+takes in julian dates of two epochs
+returns this equatorial point, in hours and degrees, precessed from the
+mean equinox of from to the mean equinox of to. right ascension stays
+continuous with this point so it may extend past 0h or 24h
+*/
+func (p *Point) Precess(from, to float64) *Point {
+	if from == to {
+		return p
+	}
+	x, y := precess(Precession(from, to), p.X(), p.Y())
+	return NewPoint2D(x, y)
+}
+
+/* takes in precession frame and equatorial point in hours and degrees
+returns precessed point with right ascension continuous with ra */
+func precess(f *Frame, ra, dec float64) (float64, float64) {
+	lon, lat := f.FromEquatorial(ra*DegreesPerHour, dec)
+	return STELLAR.NearestX(lon/DegreesPerHour, ra), lat
+}
+
+/*
+takes in julian dates of two epochs
+returns copy of this 2D sequence of equatorial coordinates precessed from
+the mean equinox of from to the mean equinox of to. vertices on the 0h
+and 24h edges of the grid only have their declination precessed so shapes
+that were cut at the edge stay cut, vertices at the poles are unchanged
+*/
+func (cs *CoordinateSeq) Precess(from, to float64) *CoordinateSeq {
+	coords := make([]float64, len(cs.Coords))
+	copy(coords, cs.Coords)
+	rval := &CoordinateSeq{coords, cs.Dims}
+	if from == to {
+		return rval
+	}
+	frame := Precession(from, to)
+	min, max := STELLAR.XRange()
+	for i := 0; i < rval.Len(); i += 1 {
+		c := rval.Get(i)
+		if math.Abs(c[1]) >= 90 {
+			continue
+		}
+		x, y := precess(frame, c[0], c[1])
+		if c[0] != min && c[0] != max {
+			c[0] = x
+		}
+		c[1] = y
+	}
+	return rval
+}
+
+/* takes in julian dates of two epochs
+returns copy of this 2D polygon precessed, see CoordinateSeq.Precess() */
+func (p *Polygon) Precess(from, to float64) (*Polygon, error) {
+	cs := p.c.Precess(from, to)
+	return NewPoly(cs.Dims, cs.Coords...)
+}
@@ -0,0 +1,244 @@
+package geom
+
+import (
+	"math"
+)
+
+/*
+maps longitude and latitude on a sphere to a plane and back.
+angles are in degrees, plane units are radians at the projection center
+*/
+type Projection interface {
+	/*
+	   takes in longitude and latitude in degrees
+	   returns plane coordinates and false if the point can't be projected
+	*/
+	Forward(lon, lat float64) (float64, float64, bool)
+	/*
+	   takes in plane coordinates
+	   returns longitude and latitude in degrees and false if the plane
+	   coordinates are outside of the projection
+	*/
+	Inverse(x, y float64) (float64, float64, bool)
+}
+
+/* takes in an angle in radians returns equivalent angle in [-pi, pi] */
+func wrapRadians(a float64) float64 {
+	if a >= -math.Pi && a <= math.Pi {
+		return a
+	}
+	a = math.Mod(a+math.Pi, 2*math.Pi)
+	if a < 0 {
+		a += 2 * math.Pi
+	}
+	return a - math.Pi
+}
+
+/*
+azimuthal projection centered on lon0, lat0.
+scale returns the radial scale factor for the cosine of the angular distance
+from center, arc returns the angular distance for a plane radius and false
+if the radius is outside of the projection
+*/
+type azimuthal struct {
+	lon0, sinLat0, cosLat0 float64
+	scale                  func(cosc float64) (float64, bool)
+	arc                    func(rho float64) (float64, bool)
+}
+
+/* create an azimuthal projection centered on lon0, lat0 in degrees */
+func newAzimuthal(lon0, lat0 float64, scale func(float64) (float64, bool),
+	arc func(float64) (float64, bool)) *azimuthal {
+	sinLat0, cosLat0 := math.Sincos(toRadians(lat0))
+	return &azimuthal{toRadians(lon0), sinLat0, cosLat0, scale, arc}
+}
+
+/* see Projection interface */
+func (a *azimuthal) Forward(lon, lat float64) (float64, float64, bool) {
+	sinLat, cosLat := math.Sincos(toRadians(lat))
+	sinDlon, cosDlon := math.Sincos(toRadians(lon) - a.lon0)
+	cosc := a.sinLat0*sinLat + a.cosLat0*cosLat*cosDlon
+	k, ok := a.scale(cosc)
+	if !ok {
+		return 0, 0, false
+	}
+	x := k * cosLat * sinDlon
+	y := k * (a.cosLat0*sinLat - a.sinLat0*cosLat*cosDlon)
+	return x, y, true
+}
+
+/* see Projection interface */
+func (a *azimuthal) Inverse(x, y float64) (float64, float64, bool) {
+	rho := math.Hypot(x, y)
+	if rho == 0 {
+		return toDegrees(a.lon0), toDegrees(math.Asin(a.sinLat0)), true
+	}
+	c, ok := a.arc(rho)
+	if !ok {
+		return 0, 0, false
+	}
+	sinc, cosc := math.Sincos(c)
+	lat := math.Asin(cosc*a.sinLat0 + y*sinc*a.cosLat0/rho)
+	lon := a.lon0 + math.Atan2(x*sinc, rho*a.cosLat0*cosc-y*a.sinLat0*sinc)
+	return toDegrees(wrapRadians(lon)), toDegrees(lat), true
+}
+
+/* linear scale of longitude and latitude */
+type plateCarree struct {
+	lon0 float64
+}
+
+/* linear scale of longitude and latitude centered on lon0 in degrees */
+func NewPlateCarree(lon0, lat0 float64) Projection {
+	return &plateCarree{toRadians(lon0)}
+}
+
+/* see Projection interface */
+func (p *plateCarree) Forward(lon, lat float64) (float64, float64, bool) {
+	return wrapRadians(toRadians(lon) - p.lon0), toRadians(lat), true
+}
+
+/* see Projection interface */
+func (p *plateCarree) Inverse(x, y float64) (float64, float64, bool) {
+	if math.Abs(x) > math.Pi || math.Abs(y) > math.Pi/2 {
+		return 0, 0, false
+	}
+	return toDegrees(wrapRadians(p.lon0 + x)), toDegrees(y), true
+}
+
+/* conformal azimuthal projection, everything but the antipode is visible */
+func NewStereographic(lon0, lat0 float64) Projection {
+	return newAzimuthal(lon0, lat0, func(cosc float64) (float64, bool) {
+		if cosc <= -0.9999 {
+			return 0, false
+		}
+		return 2 / (1 + cosc), true
+	}, func(rho float64) (float64, bool) {
+		return 2 * math.Atan(rho/2), true
+	})
+}
+
+/* view of a hemisphere from infinitely far away */
+func NewOrthographic(lon0, lat0 float64) Projection {
+	return newAzimuthal(lon0, lat0, func(cosc float64) (float64, bool) {
+		return 1, cosc >= 0
+	}, func(rho float64) (float64, bool) {
+		if rho > 1 {
+			return 0, false
+		}
+		return math.Asin(rho), true
+	})
+}
+
+/* projection from the center of the sphere, great circles are straight.
+only points well inside the hemisphere around center are visible */
+func NewGnomonic(lon0, lat0 float64) Projection {
+	return newAzimuthal(lon0, lat0, func(cosc float64) (float64, bool) {
+		if cosc < 0.01 {
+			return 0, false
+		}
+		return 1 / cosc, true
+	}, func(rho float64) (float64, bool) {
+		return math.Atan(rho), true
+	})
+}
+
+/* distances from center are true to scale */
+func NewAzimuthalEquidistant(lon0, lat0 float64) Projection {
+	return newAzimuthal(lon0, lat0, func(cosc float64) (float64, bool) {
+		if cosc <= -0.9999 {
+			return 0, false
+		}
+		if cosc >= 1 {
+			return 1, true
+		}
+		c := math.Acos(cosc)
+		return c / math.Sin(c), true
+	}, func(rho float64) (float64, bool) {
+		return rho, rho <= math.Pi
+	})
+}
+
+/* equal area projection of the whole sphere into an ellipse */
+type mollweide struct {
+	lon0 float64
+}
+
+/* equal area projection of the whole sphere centered on lon0 in degrees */
+func NewMollweide(lon0, lat0 float64) Projection {
+	return &mollweide{toRadians(lon0)}
+}
+
+/* see Projection interface */
+func (m *mollweide) Forward(lon, lat float64) (float64, float64, bool) {
+	phi := toRadians(lat)
+	dlon := wrapRadians(toRadians(lon) - m.lon0)
+	/* solve 2 theta + sin(2 theta) = pi sin(phi) with newton's method */
+	theta := phi
+	target := math.Pi * math.Sin(phi)
+	for i := 0; i < 20; i += 1 {
+		f := 2*theta + math.Sin(2*theta) - target
+		df := 2 + 2*math.Cos(2*theta)
+		if df < 1e-12 {
+			break
+		}
+		delta := f / df
+		theta -= delta
+		if math.Abs(delta) < 1e-12 {
+			break
+		}
+	}
+	x := 2 * math.Sqrt2 / math.Pi * dlon * math.Cos(theta)
+	y := math.Sqrt2 * math.Sin(theta)
+	return x, y, true
+}
+
+/* see Projection interface */
+func (m *mollweide) Inverse(x, y float64) (float64, float64, bool) {
+	if math.Abs(y) > math.Sqrt2 {
+		return 0, 0, false
+	}
+	theta := math.Asin(y / math.Sqrt2)
+	cosTheta := math.Cos(theta)
+	if cosTheta == 0 {
+		return toDegrees(m.lon0), toDegrees(math.Copysign(math.Pi/2, y)),
+			x == 0
+	}
+	dlon := math.Pi * x / (2 * math.Sqrt2 * cosTheta)
+	if math.Abs(dlon) > math.Pi {
+		return 0, 0, false
+	}
+	lat := math.Asin((2*theta + math.Sin(2*theta)) / math.Pi)
+	return toDegrees(wrapRadians(m.lon0 + dlon)), toDegrees(lat), true
+}
+
+/* equal area projection of the whole sphere with curved parallels */
+type hammerAitoff struct {
+	lon0 float64
+}
+
+/* hammer-aitoff projection of the whole sphere centered on lon0 in degrees */
+func NewHammerAitoff(lon0, lat0 float64) Projection {
+	return &hammerAitoff{toRadians(lon0)}
+}
+
+/* see Projection interface */
+func (h *hammerAitoff) Forward(lon, lat float64) (float64, float64, bool) {
+	sinLat, cosLat := math.Sincos(toRadians(lat))
+	half := wrapRadians(toRadians(lon)-h.lon0) / 2
+	z := math.Sqrt(1 + cosLat*math.Cos(half))
+	x := 2 * math.Sqrt2 * cosLat * math.Sin(half) / z
+	y := math.Sqrt2 * sinLat / z
+	return x, y, true
+}
+
+/* see Projection interface */
+func (h *hammerAitoff) Inverse(x, y float64) (float64, float64, bool) {
+	if x*x/8+y*y/2 > 1 {
+		return 0, 0, false
+	}
+	z := math.Sqrt(1 - x*x/16 - y*y/4)
+	dlon := 2 * math.Atan2(z*x, 2*(2*z*z-1))
+	lat := math.Asin(z * y)
+	return toDegrees(wrapRadians(h.lon0 + dlon)), toDegrees(lat), true
+}
@@ -0,0 +1,76 @@
+package geom
+
+import (
+	"math"
+)
+
+/* weather that bends and dims starlight near the horizon */
+type Atmosphere struct {
+	/* degrees celsius */
+	Temperature float64
+	/* millibars, zero for no atmosphere */
+	Pressure float64
+}
+
+/* conditions the refraction formulas are given for */
+var StandardAtmosphere = &Atmosphere{10, 1010}
+
+/* true altitude in degrees below which refraction stops growing, the
+formulas don't hold much below the horizon */
+const minRefractionAltitude = -1.0
+
+/* V band magnitudes lost per airmass at standard pressure */
+const extinctionCoefficient = 0.2
+
+/* returns refraction scaled from standard conditions */
+func (a *Atmosphere) scale() float64 {
+	return a.Pressure / StandardAtmosphere.Pressure *
+		(273 + StandardAtmosphere.Temperature) / (273 + a.Temperature)
+}
+
+/*
+takes in true altitude in degrees
+returns degrees the altitude is lifted by refraction using Saemundsson's
+formula
+*/
+func (a *Atmosphere) Refraction(alt float64) float64 {
+	alt = math.Max(alt, minRefractionAltitude)
+	minutes := 1.02 / math.Tan(toRadians(alt+10.3/(alt+5.11)))
+	return minutes / 60 * a.scale()
+}
+
+/* takes in true altitude in degrees returns apparent altitude */
+func (a *Atmosphere) Apparent(alt float64) float64 {
+	return alt + a.Refraction(alt)
+}
+
+/*
+takes in apparent altitude in degrees
+returns true altitude using Bennett's formula, the inverse of Apparent()
+to within a few arc seconds
+*/
+func (a *Atmosphere) TrueAltitude(apparent float64) float64 {
+	if apparent < a.Apparent(minRefractionAltitude) {
+		return apparent - a.Refraction(minRefractionAltitude)
+	}
+	minutes := 1 / math.Tan(toRadians(apparent+7.31/(apparent+4.4)))
+	return apparent - minutes/60*a.scale()
+}
+
+/*
+takes in apparent altitude in degrees
+returns air mass, 1 at the zenith and about 38 at the horizon, using the
+Kasten and Young formula
+*/
+func Airmass(apparent float64) float64 {
+	alt := math.Max(apparent, 0)
+	return 1 / (math.Sin(toRadians(alt)) + 0.50572*math.Pow(alt+6.07995,
+		-1.6364))
+}
+
+/* takes in apparent altitude in degrees
+returns magnitudes starlight is dimmed by */
+func (a *Atmosphere) Extinction(apparent float64) float64 {
+	return extinctionCoefficient * a.Pressure /
+		StandardAtmosphere.Pressure * Airmass(apparent)
+}
@@ -0,0 +1,65 @@
+package geom
+
+import (
+	"math"
+)
+
+/* degrees of arc per hour of right ascension */
+const DegreesPerHour = 15.0
+
+/* convert degrees to radians */
+func toRadians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+/* convert radians to degrees */
+func toDegrees(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+/*
+takes in two equatorial coordinates with right ascension in hours and
+declination in degrees
+returns the great circle angle between them in degrees
+uses the vincenty formula which is stable for small and antipodal angles
+*/
+func AngularSeparation(ra0, dec0, ra1, dec1 float64) float64 {
+	dra := toRadians((ra1 - ra0) * DegreesPerHour)
+	d0 := toRadians(dec0)
+	d1 := toRadians(dec1)
+	sinDra, cosDra := math.Sincos(dra)
+	sin0, cos0 := math.Sincos(d0)
+	sin1, cos1 := math.Sincos(d1)
+	x := cos1 * sinDra
+	y := cos0*sin1 - sin0*cos1*cosDra
+	num := math.Sqrt(x*x + y*y)
+	den := sin0*sin1 + cos0*cos1*cosDra
+	return toDegrees(math.Atan2(num, den))
+}
+
+/*
+takes in another equatorial point
+returns the great circle angle between the points in degrees
+see AngularSeparation()
+*/
+func (p *Point) AngularDistance(other *Point) float64 {
+	return AngularSeparation(p.X(), p.Y(), other.X(), other.Y())
+}
+
+/*
+takes in an equatorial center point and radius in degrees
+returns the half width in hours of right ascension of the smallest
+box that covers all points within radius of center.
+returns 12 if the circle includes a pole
+*/
+func RAHalfWidth(center *Point, radius float64) float64 {
+	dec := math.Abs(center.Y())
+	if dec+radius >= 90 {
+		return 12
+	}
+	ratio := math.Sin(toRadians(radius)) / math.Cos(toRadians(dec))
+	if ratio >= 1 {
+		return 12
+	}
+	return toDegrees(math.Asin(ratio)) / DegreesPerHour
+}
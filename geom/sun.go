@@ -0,0 +1,28 @@
+package geom
+
+import (
+	"math"
+	"time"
+)
+
+/* julian date of the unix epoch */
+const unixEpoch = 2440587.5
+
+/* takes in time returns julian date */
+func JulianDate(t time.Time) float64 {
+	return unixEpoch + float64(t.UnixNano())/(86400*1e9)
+}
+
+/*
+takes in julian date
+returns apparent ecliptic longitude of the sun in degrees, good to about
+a hundredth of a degree for a few centuries around J2000
+*/
+func SunLongitude(jd float64) float64 {
+	n := jd - J2000
+	/* mean longitude and mean anomaly */
+	l := 280.460 + 0.9856474*n
+	g := toRadians(357.528 + 0.9856003*n)
+	lon := l + 1.915*math.Sin(g) + 0.020*math.Sin(2*g)
+	return ECLIPTIC.WrapX(lon)
+}
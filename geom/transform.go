@@ -13,6 +13,28 @@ type PointTransform struct {
 	Width  int
 	Height int
 	gd     *GridDef
+	/* nil for a linear scale of grid coordinates to pixels */
+	proj Projection
+	/* sky frame projected, nil for equatorial */
+	frame *Frame
+	/* frame longitude increases to the right, see Frame.Mirrored() */
+	mirror bool
+	/* points below minLat in frame aren't visible, see HideBelow() */
+	hide   bool
+	minLat float64
+	/* lifts frame latitudes as altitudes, nil for none, see Refract() */
+	atmosphere *Atmosphere
+	/* plane coordinates of the upper left corner of the image */
+	originX float64
+	originY float64
+	/* plane units per pixel */
+	scaleX float64
+	scaleY float64
+}
+
+/* degrees per grid unit along x, right ascension hours are 15 degrees */
+func (gd *GridDef) xDegrees() float64 {
+	return 180 / gd.xoffset
 }
 
 /*
@@ -28,7 +50,193 @@ func CreateTransform(lowerLeft, upperRight *Point, width, height int,
 	maxx := math.Max(lowerLeft.X(), upperRight.X())
 	maxy := math.Max(lowerLeft.Y(), upperRight.Y())
 	max := NewPoint2D(maxx, maxy)
-	return &PointTransform{dx, dy, max, width, height, gd}
+	return &PointTransform{Dx: dx, Dy: dy, Max: max, Width: width,
+		Height: height, gd: gd}
+}
+
+/* constructor for projections centered on lon0, lat0 in degrees */
+type ProjectionFunc func(lon0, lat0 float64) Projection
+
+/* samples along each edge of bounds when fitting a projection to an image */
+const edgeSamples = 64
+
+/*
+create transform object from lower/upper bounds, image dimensions,
+grid definition and projection constructor. the projection is centered
+on the middle of the bounds and scaled so the projected bounds fit inside
+the image without distortion
+*/
+func CreateProjectedTransform(lowerLeft, upperRight *Point, width,
+	height int, gd *GridDef, newProj ProjectionFunc) *PointTransform {
+	xdeg := gd.xDegrees()
+	lower := NewPoint2D(lowerLeft.X()*xdeg, lowerLeft.Y())
+	upper := NewPoint2D(upperRight.X()*xdeg, upperRight.Y())
+	return CreateFrameTransform(lower, upper, width, height, gd, nil, newProj)
+}
+
+/*
+create transform object from lower/upper bounds in degrees of a sky frame,
+image dimensions, grid definition of the points that will be transformed,
+the frame and a projection constructor. frame is nil for equatorial bounds.
+newProj is nil to scale frame coordinates linearly to fill the image,
+otherwise the projection is centered on the middle of the bounds and
+scaled so the projected bounds fit inside the image without distortion
+*/
+func CreateFrameTransform(lower, upper *Point, width, height int,
+	gd *GridDef, frame *Frame, newProj ProjectionFunc) *PointTransform {
+	x0, x1 := lower.X(), upper.X()
+	y0, y1 := lower.Y(), upper.Y()
+	lat0 := (y0 + y1) / 2
+	if math.Abs(x1-x0) >= 360 {
+		/* center caps with every longitude on the pole */
+		if math.Max(y0, y1) >= 90 {
+			lat0 = 90
+		} else if math.Min(y0, y1) <= -90 {
+			lat0 = -90
+		}
+	}
+	stretch := newProj == nil
+	if stretch {
+		newProj = NewPlateCarree
+	}
+	proj := newProj((x0+x1)/2, lat0)
+	rval := &PointTransform{Width: width, Height: height, gd: gd,
+		proj: proj, frame: frame, mirror: frame != nil && frame.Mirrored()}
+	/* find extent of bounds in the plane */
+	minx, miny := math.Inf(1), math.Inf(1)
+	maxx, maxy := math.Inf(-1), math.Inf(-1)
+	for i := 0; i <= edgeSamples; i += 1 {
+		f := float64(i) / edgeSamples
+		x := x0 + (x1-x0)*f
+		y := y0 + (y1-y0)*f
+		edges := [][2]float64{{x, y0}, {x, y1}, {x0, y}, {x1, y},
+			{x, (y0 + y1) / 2}, {(x0 + x1) / 2, y}}
+		for _, e := range edges {
+			px, py, ok := rval.projectFrame(e[0], e[1])
+			if ok {
+				minx, maxx = math.Min(minx, px), math.Max(maxx, px)
+				miny, maxy = math.Min(miny, py), math.Max(maxy, py)
+			}
+		}
+	}
+	if minx > maxx {
+		/* nothing visible, fall back to the whole plane near center */
+		minx, miny, maxx, maxy = -math.Pi, -math.Pi, math.Pi, math.Pi
+	}
+	rval.scaleX = (maxx - minx) / float64(width)
+	rval.scaleY = (maxy - miny) / float64(height)
+	if !stretch {
+		rval.scaleX = math.Max(rval.scaleX, rval.scaleY)
+		rval.scaleY = rval.scaleX
+	}
+	if rval.scaleX <= 0 || rval.scaleY <= 0 {
+		rval.scaleX, rval.scaleY = 1/float64(width), 1/float64(height)
+	}
+	rval.originX = (minx+maxx)/2 - rval.scaleX*float64(width)/2
+	rval.originY = (miny+maxy)/2 + rval.scaleY*float64(height)/2
+	rval.Dy = toDegrees(rval.scaleY)
+	rval.Dx = toDegrees(rval.scaleX) / gd.xDegrees()
+	rval.Max = NewPoint2D(math.Max(x0, x1), math.Max(y0, y1))
+	return rval
+}
+
+/* takes in grid coordinates
+returns plane coordinates in image orientation and false if not visible */
+func (pt *PointTransform) project(x, y float64) (float64, float64, bool) {
+	lon, lat := x*pt.gd.xDegrees(), y
+	if pt.frame != nil {
+		lon, lat = pt.frame.FromEquatorial(lon, lat)
+	}
+	if pt.atmosphere != nil {
+		lat = pt.atmosphere.Apparent(lat)
+	}
+	if pt.hidden(lat) {
+		return 0, 0, false
+	}
+	return pt.projectFrame(lon, lat)
+}
+
+/*
+hide points below latitude lat in degrees of the projected frame,
+such as points below the horizon. only projected transforms hide points
+*/
+func (pt *PointTransform) HideBelow(lat float64) {
+	pt.hide = true
+	pt.minLat = lat
+}
+
+/*
+lift frame latitudes by refraction in atmosphere a, treating them as
+altitudes in a horizon frame. hidden latitudes are apparent altitudes,
+see HideBelow()
+*/
+func (pt *PointTransform) Refract(a *Atmosphere) {
+	pt.atmosphere = a
+}
+
+/* returns true if frame latitude lat isn't visible, see HideBelow().
+points less than half a pixel below are visible so lines along the limit
+can be drawn */
+func (pt *PointTransform) hidden(lat float64) bool {
+	return pt.hide && lat < pt.minLat-pt.Dy/2
+}
+
+/* takes in frame longitude and latitude in degrees
+returns plane coordinates in image orientation and false if not visible */
+func (pt *PointTransform) projectFrame(lon, lat float64) (float64, float64,
+	bool) {
+	px, py, ok := pt.proj.Forward(lon, lat)
+	if pt.gd.xIncreasesRight == pt.mirror {
+		px = -px
+	}
+	if !pt.gd.yIncreasesUp {
+		py = -py
+	}
+	return px, py, ok
+}
+
+/* degrees from the x edge of the grid for the cuts that split shapes */
+const seamDegrees = 1.0
+
+/*
+takes in 2D segment end points in grid coordinates
+returns true if the segment is a cut along the x edge of the grid.
+shapes are split there so they fit inside the grid, projections would
+show the cut as an extra line
+*/
+func (pt *PointTransform) OnSeam(c0, c1 []float64) bool {
+	min, max := pt.gd.XRange()
+	limit := seamDegrees / pt.gd.xDegrees()
+	for _, edge := range []float64{min, max} {
+		if (c0[0] == edge || c1[0] == edge) &&
+			math.Abs(c0[0]-edge) < limit && math.Abs(c1[0]-edge) < limit {
+			return true
+		}
+	}
+	return false
+}
+
+/* returns true if transform uses a projection instead of a linear scale */
+func (pt *PointTransform) Projected() bool {
+	return pt.proj != nil
+}
+
+/*
+take in a 2D point in spatial dimensions
+return image pixel location and false if the point isn't visible
+in the projection
+*/
+func (pt *PointTransform) TransformVisible(x, y float64) (*image.Point, bool) {
+	if pt.proj == nil {
+		return pt.TransformXY(x, y), true
+	}
+	px, py, ok := pt.project(x, y)
+	if !ok {
+		return nil, false
+	}
+	xpix := int(math.Floor((px - pt.originX) / pt.scaleX))
+	ypix := int(math.Floor((pt.originY - py) / pt.scaleY))
+	return &image.Point{xpix, ypix}, true
 }
 
 /* take in a point in spatial dimensions, return image pixel location */
@@ -43,6 +251,13 @@ func (pt *PointTransform) TransformCoord(c []float64) *image.Point {
 
 /* take in a 2D point in spatial dimensions, return image pixel location */
 func (pt *PointTransform) TransformXY(x, y float64) *image.Point {
+	if pt.proj != nil {
+		if rval, ok := pt.TransformVisible(x, y); ok {
+			return rval
+		}
+		/* off image for points that aren't visible */
+		return &image.Point{-pt.Width, -pt.Height}
+	}
 	rawX := (pt.Max.X() - x) / pt.Dx
 	if pt.gd.xIncreasesRight {
 		rawX = float64(pt.Width) - rawX
@@ -56,8 +271,12 @@ func (pt *PointTransform) TransformXY(x, y float64) *image.Point {
 	return &image.Point{xpix, ypix}
 }
 
-/* take in an image pixel and return a point in spatial dimensions */
+/* take in an image pixel and return a point in spatial dimensions
+returns nil if pixel is outside of the projection */
 func (pt *PointTransform) Reverse(p *image.Point) *Point {
+	if pt.proj != nil {
+		return pt.reverseXY(float64(p.X)+0.5, float64(p.Y)+0.5)
+	}
 	tmpX := float64(p.X)
 	if pt.gd.xIncreasesRight {
 		tmpX += float64(pt.Width)
@@ -75,3 +294,110 @@ func (pt *PointTransform) Reverse(p *image.Point) *Point {
 	tmpY = -tmpY
 	return NewPoint2D(tmpX, tmpY)
 }
+
+/* takes in fractional pixel coordinates
+returns the projected grid point or nil if outside of the projection
+or hidden, see HideBelow() */
+func (pt *PointTransform) reverseXY(x, y float64) *Point {
+	px := pt.originX + x*pt.scaleX
+	py := pt.originY - y*pt.scaleY
+	if pt.gd.xIncreasesRight == pt.mirror {
+		px = -px
+	}
+	if !pt.gd.yIncreasesUp {
+		py = -py
+	}
+	lon, lat, ok := pt.proj.Inverse(px, py)
+	if !ok || pt.hidden(lat) {
+		return nil
+	}
+	if pt.atmosphere != nil {
+		lat = pt.atmosphere.TrueAltitude(lat)
+	}
+	if pt.frame != nil {
+		lon, lat = pt.frame.ToEquatorial(lon, lat)
+	}
+	return NewPoint2D(pt.gd.WrapX(lon/pt.gd.xDegrees()), lat)
+}
+
+/* pixels between samples when finding the bounds of a projected image */
+const boundsStep = 16
+
+/*
+returns grid bounds that include everything drawn in the image.
+x bounds may extend past the grid edges, see SplitBBox2D()
+*/
+func (pt *PointTransform) Bounds() *BoundingBox {
+	if pt.proj == nil {
+		minx := pt.Max.X() - pt.Dx*float64(pt.Width)
+		miny := pt.Max.Y() - pt.Dy*float64(pt.Height)
+		return NewBBox2D(minx, miny, pt.Max.X(), pt.Max.Y())
+	}
+	xmin, xmax := pt.gd.XRange()
+	ymin, ymax := pt.gd.ycenter-pt.gd.yoffset, pt.gd.ycenter+pt.gd.yoffset
+	centerx := pt.gd.xcenter
+	if center := pt.reverseXY(float64(pt.Width)/2,
+		float64(pt.Height)/2); center != nil {
+		centerx = center.X()
+	}
+	minx, miny := math.Inf(1), math.Inf(1)
+	maxx, maxy := math.Inf(-1), math.Inf(-1)
+	w, h := float64(pt.Width), float64(pt.Height)
+	for y := 0.0; y <= h+boundsStep; y += boundsStep {
+		for x := 0.0; x <= w+boundsStep; x += boundsStep {
+			p := pt.reverseXY(math.Min(x, w), math.Min(y, h))
+			if p == nil {
+				continue
+			}
+			px := pt.gd.NearestX(p.X(), centerx)
+			minx, maxx = math.Min(minx, px), math.Max(maxx, px)
+			miny, maxy = math.Min(miny, p.Y()), math.Max(maxy, p.Y())
+		}
+	}
+	if minx > maxx {
+		return NewBBox2D(xmin, ymin, xmax, ymax)
+	}
+	/* samples can miss the edges of what is drawn */
+	miny = math.Max(miny-boundsStep*pt.Dy, ymin)
+	maxy = math.Min(maxy+boundsStep*pt.Dy, ymax)
+	minx -= boundsStep * pt.Dx
+	maxx += boundsStep * pt.Dx
+	for _, pole := range []float64{ymin, ymax} {
+		pix, ok := pt.TransformVisible(centerx, pole)
+		if ok && pix.X >= 0 && pix.X <= pt.Width && pix.Y >= 0 &&
+			pix.Y <= pt.Height {
+			/* every x value meets at a visible pole */
+			miny, maxy = math.Min(miny, pole), math.Max(maxy, pole)
+			minx, maxx = centerx-(xmax-xmin)/2, centerx+(xmax-xmin)/2
+		}
+	}
+	if maxx-minx >= xmax-xmin {
+		minx, maxx = centerx-(xmax-xmin)/2, centerx+(xmax-xmin)/2
+	}
+	return NewBBox2D(minx, miny, maxx, maxy)
+}
+
+/* most pieces a segment is split into by Densify() */
+const maxPieces = 1024
+
+/*
+takes in 2D segment end points in grid coordinates and a step in degrees
+returns points along the segment no more than step degrees apart,
+including both end points
+*/
+func (pt *PointTransform) Densify(c0, c1 []float64, step float64) [][]float64 {
+	dx := (c1[0] - c0[0]) * pt.gd.xDegrees()
+	dy := c1[1] - c0[1]
+	n := int(math.Ceil(math.Max(math.Abs(dx), math.Abs(dy)) / step))
+	if n < 1 {
+		n = 1
+	} else if n > maxPieces {
+		n = maxPieces
+	}
+	rval := make([][]float64, n+1)
+	for i := 0; i <= n; i += 1 {
+		f := float64(i) / float64(n)
+		rval[i] = []float64{c0[0] + (c1[0]-c0[0])*f, c0[1] + (c1[1]-c0[1])*f}
+	}
+	return rval
+}
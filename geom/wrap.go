@@ -0,0 +1,83 @@
+package geom
+
+import (
+	"math"
+)
+
+/* piece of a bounding box that lies inside grid bounds */
+type BBoxPart struct {
+	*BoundingBox
+	/* added to x values in the part to get the requested x values */
+	Shift float64
+}
+
+/* range of geohash query strings, see BBoxHash() */
+type HashRange struct {
+	Min string
+	Max string
+}
+
+/* returns the minimum and maximum x values of grid */
+func (gd *GridDef) XRange() (float64, float64) {
+	return gd.xcenter - gd.xoffset, gd.xcenter + gd.xoffset
+}
+
+/* takes in an x value that may be outside of the grid
+returns the equivalent x value inside the grid */
+func (gd *GridDef) WrapX(x float64) float64 {
+	min, max := gd.XRange()
+	width := max - min
+	rval := math.Mod(x-min, width)
+	if rval < 0 {
+		rval += width
+	}
+	return rval + min
+}
+
+/*
+takes in 2D bounds where x values may extend past the edges of the grid,
+for example -1 to 1 hours in stellar coordinates
+returns the parts of the bounds that lie inside the grid, split where
+the bounds wrap across a grid edge
+*/
+func SplitBBox2D(x0, y0, x1, y1 float64, gd *GridDef) []*BBoxPart {
+	gridMin, gridMax := gd.XRange()
+	width := gridMax - gridMin
+	minx, maxx := math.Min(x0, x1), math.Max(x0, x1)
+	miny, maxy := math.Min(y0, y1), math.Max(y0, y1)
+	first := math.Floor((minx - gridMin) / width)
+	last := math.Floor((maxx - gridMin) / width)
+	rval := make([]*BBoxPart, 0, 2)
+	for k := first; k <= last; k += 1 {
+		shift := k * width
+		partMin := math.Max(minx-shift, gridMin)
+		partMax := math.Min(maxx-shift, gridMax)
+		/* skip parts that only touch a grid edge */
+		if partMax > partMin || (minx == maxx && len(rval) == 0) {
+			bbox := NewBBox2D(partMin, miny, partMax, maxy)
+			rval = append(rval, &BBoxPart{bbox, shift})
+		}
+	}
+	return rval
+}
+
+/*
+takes in bounds where x values may extend past the edges of the grid
+returns geohash query ranges for each part of the bounds inside the grid
+see SplitBBox2D() and BBoxHash()
+*/
+func SplitBBoxHash(lower, upper *Point, gd *GridDef) []HashRange {
+	parts := SplitBBox2D(lower.X(), lower.Y(), upper.X(), upper.Y(), gd)
+	rval := make([]HashRange, len(parts))
+	for i, part := range parts {
+		min, max := BBoxHash(part.Lower(), part.Upper(), gd)
+		rval[i] = HashRange{min, max}
+	}
+	return rval
+}
+
+/* takes in an x value and a reference x value
+returns the copy of x across grid wraps that is closest to ref */
+func (gd *GridDef) NearestX(x, ref float64) float64 {
+	return ref + gd.WrapX(x-ref+gd.xcenter) - gd.xcenter
+}
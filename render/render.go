@@ -92,6 +92,17 @@ func CreateTransparent(width, height int) draw.Image {
 	return image.NewRGBA(image.Rect(0, 0, width, height))
 }
 
+/*
+takes in point in spatial dimensions to render onto image using style
+points that aren't visible in the transform's projection are skipped
+*/
+func RenderPoint(img draw.Image, p *geom.Point, t *geom.PointTransform,
+	pstyle *style.PointStyle) {
+	if pix, ok := t.TransformVisible(p.X(), p.Y()); ok {
+		Render(img, pix, pstyle)
+	}
+}
+
 /*
 takes in point to render onto image using style
 */
@@ -128,17 +139,18 @@ func rfpart(x float64) float64 {
 	return 1 - fpart(x)
 }
 
-/* draw point x,y using grayscale c (0..1) */
-func plot(img draw.Image, x, y int, c float64) {
-	// TODO use color from polygon style
-	gray := uint8(128 * c)
-	color := color.RGBA{gray, gray, gray, 255}
-	img.Set(x, y, color)
+/* draw point x,y in col with brightness c (0..1).
+lines are drawn at half brightness so they don't hide stars */
+func plot(img draw.Image, x, y int, c float64, col color.Color) {
+	r, g, b, _ := col.RGBA()
+	scale := c * 128 / 0xffff
+	img.Set(x, y, color.RGBA{uint8(float64(r) * scale),
+		uint8(float64(g) * scale), uint8(float64(b) * scale), 255})
 }
 
 /* line endpoint rendering for Wu's line drawing */
 func drawEndpoint(img draw.Image, x, y, gradient float64,
-	first, steep bool) float64 {
+	first, steep bool, col color.Color) float64 {
 	xend := round(x)
 	yend := y + gradient*(xend-x)
 	var xgap float64
@@ -150,18 +162,18 @@ func drawEndpoint(img draw.Image, x, y, gradient float64,
 	xpix := int(xend)
 	ypix := int(yend)
 	if steep {
-		plot(img, ypix, xpix, rfpart(yend)*xgap)
-		plot(img, ypix+1, xpix, fpart(yend)*xgap)
+		plot(img, ypix, xpix, rfpart(yend)*xgap, col)
+		plot(img, ypix+1, xpix, fpart(yend)*xgap, col)
 	} else {
-		plot(img, xpix, ypix, rfpart(yend)*xgap)
-		plot(img, xpix, ypix+1, fpart(yend)*xgap)
+		plot(img, xpix, ypix, rfpart(yend)*xgap, col)
+		plot(img, xpix, ypix+1, fpart(yend)*xgap, col)
 	}
 	return yend + gradient
 }
 
 /* draw a line from p0 to p1 on img, uses Wu's algorithm */
 func RenderLine(img draw.Image, p0, p1 *image.Point, s *style.PolygonStyle) {
-	// TODO use style for line width and color
+	// TODO use style for line width
 	steep := math.Abs(float64(p1.Y-p0.Y)) > math.Abs(float64(p1.X-p0.X))
 	x0, y0 := float64(p0.X), float64(p0.Y)
 	x1, y1 := float64(p1.X), float64(p1.Y)
@@ -178,27 +190,44 @@ func RenderLine(img draw.Image, p0, p1 *image.Point, s *style.PolygonStyle) {
 	gradient := dy / dx
 	xpix1 := int(round(x0))
 	xpix2 := int(round(x1))
-	intery := drawEndpoint(img, x0, y0, gradient, true, steep)
-	drawEndpoint(img, x1, y1, gradient, false, steep)
+	col := s.Color
+	intery := drawEndpoint(img, x0, y0, gradient, true, steep, col)
+	drawEndpoint(img, x1, y1, gradient, false, steep, col)
 
 	for x := xpix1 + 1; x < xpix2; x += 1 {
 		if steep {
-			plot(img, int(intery), x, rfpart(intery))
-			plot(img, int(intery)+1, x, fpart(intery))
+			plot(img, int(intery), x, rfpart(intery), col)
+			plot(img, int(intery)+1, x, fpart(intery), col)
 		} else {
-			plot(img, x, int(intery), rfpart(intery))
-			plot(img, x, int(intery)+1, fpart(intery))
+			plot(img, x, int(intery), rfpart(intery), col)
+			plot(img, x, int(intery)+1, fpart(intery), col)
 		}
 		intery += gradient
 	}
 }
 
+/* largest gap in degrees between points of lines drawn in a projection */
+const densifyDegrees = 1.0
+
+/* gap in pixels between points of lines drawn in zoomed in projections */
+const densifyPixels = 4.0
+
+/*
+draw line segments between coordinates on img.
+in projections segments are split so straight grid lines curve and
+segments that aren't visible, that jump across the projection or that
+only split shapes at the grid edge are skipped
+*/
 func RenderSeq(img draw.Image, coords *geom.CoordinateSeq, t *geom.PointTransform,
 	s *style.PolygonStyle) {
 	polyLen := coords.Len()
 	if polyLen < 2 {
 		return
 	}
+	if t.Projected() {
+		renderProjectedSeq(img, coords, t, s)
+		return
+	}
 	prev := t.TransformCoord(coords.Get(0))
 	for i := 1; i < polyLen; i += 1 {
 		curr := t.TransformCoord(coords.Get(i))
@@ -207,6 +236,34 @@ func RenderSeq(img draw.Image, coords *geom.CoordinateSeq, t *geom.PointTransfor
 	}
 }
 
+/* see RenderSeq() */
+func renderProjectedSeq(img draw.Image, coords *geom.CoordinateSeq,
+	t *geom.PointTransform, s *style.PolygonStyle) {
+	/* dy is degrees per pixel */
+	step := math.Min(densifyPixels*t.Dy, densifyDegrees)
+	first := coords.Get(0)
+	prev, prevOk := t.TransformVisible(first[0], first[1])
+	for i := 1; i < coords.Len(); i += 1 {
+		c0, c1 := coords.Get(i-1), coords.Get(i)
+		seam := t.OnSeam(c0, c1)
+		for _, c := range t.Densify(c0, c1, step)[1:] {
+			curr, ok := t.TransformVisible(c[0], c[1])
+			if ok && prevOk && !seam && !jumps(prev, curr, t) {
+				RenderLine(img, prev, curr, s)
+			}
+			prev, prevOk = curr, ok
+		}
+	}
+}
+
+/* returns true if line between p0 and p1 crosses the edge of a projection
+instead of joining neighbouring points */
+func jumps(p0, p1 *image.Point, t *geom.PointTransform) bool {
+	dx, dy := p1.X-p0.X, p1.Y-p0.Y
+	return dx > t.Width/2 || -dx > t.Width/2 || dy > t.Height/2 ||
+		-dy > t.Height/2
+}
+
 /* draw all line segments in polygon on img */
 func RenderPoly(img draw.Image, p *geom.Polygon, t *geom.PointTransform,
 	s *style.PolygonStyle) {
@@ -71,6 +71,16 @@ func TestLines(t *testing.T) {
 	writeImg(t, img, "/tmp/lines.png")
 }
 
+func TestLineColor(t *testing.T) {
+	img := Create(16, 16, color.Black)
+	red := style.NewPolyStyle(1, color.RGBA{255, 0, 0, 255})
+	RenderLine(img, &image.Point{0, 8}, &image.Point{15, 8}, red)
+	r, g, b, _ := img.At(8, 8).RGBA()
+	if r>>8 != 128 || g != 0 || b != 0 {
+		t.Errorf("expected half bright red, got %v", img.At(8, 8))
+	}
+}
+
 func TestPolys(t *testing.T) {
 	img := Create(256, 256, color.Black)
 	s := style.NewPolyStyle(1, color.White)
@@ -0,0 +1,45 @@
+// +build appengine
+
+package starmap
+
+import (
+	"appengine"
+	"appengine/memcache"
+	"net/http"
+)
+
+/* tile cache backed by app engine memcache */
+type MemcacheTiles struct{}
+
+/* see TileCache interface */
+func (c MemcacheTiles) Get(r *http.Request, key *TileKey) ([]byte, error) {
+	item, err := memcache.Get(appengine.NewContext(r), key.String())
+	if err == memcache.ErrCacheMiss {
+		return nil, ErrCacheMiss
+	} else if err != nil {
+		return nil, err
+	}
+	return item.Value, nil
+}
+
+/* see TileCache interface
+concurrent requests for the same tile are not an error */
+func (c MemcacheTiles) Add(r *http.Request, key *TileKey, tile []byte) error {
+	item := &memcache.Item{Key: key.String(), Value: tile}
+	err := memcache.Add(appengine.NewContext(r), item)
+	if err == memcache.ErrNotStored {
+		return nil
+	}
+	return err
+}
+
+/* app engine entry point, paths are relative to app.yaml */
+func init() {
+	requestLogger = func(r *http.Request) Logger {
+		return appengine.NewContext(r)
+	}
+	SetTileCache(MemcacheTiles{})
+	/* load errors are reported per request */
+	Load("data", "templates")
+	http.HandleFunc("/", Handler)
+}
@@ -22,6 +22,11 @@ const (
 	wkt_end   = iota
 )
 
+/* the IAU boundaries are defined for B1875, the data files have already
+been precessed to J2000. boundaries are fixed on the sky, so they are
+precessed once when loaded to the equinox of the star catalog */
+const constellationEpoch = geom.J2000
+
 /* nested json polygon config struct */
 type PolyInfo struct {
 	WktFile    string
@@ -46,8 +51,13 @@ type Constellation struct {
 
 type Constellations []*Constellation
 
-/* load contellation objects from static data directory */
-func LoadConstellations(constDir string) (Constellations, error) {
+/*
+load contellation objects from static data directory
+takes in julian dates of the epoch that coordinates in the data files are
+for and the epoch to precess them to, see geom.JulianEpoch()
+*/
+func LoadConstellations(constDir string, from, to float64) (Constellations,
+	error) {
 	infos, err := ioutil.ReadDir(constDir)
 	if err != nil {
 		return nil, err
@@ -70,7 +80,13 @@ func LoadConstellations(constDir string) (Constellations, error) {
 					return nil, fmt.Errorf("Unable to parse %v: %v",
 						fullWktPath, err)
 				}
+				poly, err = poly.Precess(from, to)
+				if err != nil {
+					return nil, fmt.Errorf("Unable to precess %v: %v",
+						fullWktPath, err)
+				}
 				constel.PolyInfos[i].Geom = poly
+				precessLabel(constel.PolyInfos[i], from, to)
 			}
 			for i := range constel.StringInfos {
 				wktFile := constel.StringInfos[i].WktFile
@@ -80,6 +96,9 @@ func LoadConstellations(constDir string) (Constellations, error) {
 					return nil, fmt.Errorf("Unable to parse %v: %v",
 						fullWktPath, err)
 				}
+				for j, line := range lines {
+					lines[j] = line.Precess(from, to)
+				}
 				constel.StringInfos[i].Lines = lines
 			}
 			rval = append(rval, constel)
@@ -88,6 +107,16 @@ func LoadConstellations(constDir string) (Constellations, error) {
 	return rval, nil
 }
 
+/* precess label point of polygon info in place */
+func precessLabel(info *PolyInfo, from, to float64) {
+	if len(info.LabelPoint) < 2 || from == to {
+		return
+	}
+	p := geom.NewPoint2D(info.LabelPoint[0], info.LabelPoint[1])
+	p = p.Precess(from, to)
+	info.LabelPoint[0], info.LabelPoint[1] = p.X(), p.Y()
+}
+
 /* parse constellaton JSON config file */
 func readJsonFile(path string) (*Constellation, error) {
 	f, err := os.Open(path)
@@ -0,0 +1,101 @@
+package starmap
+
+import (
+	"geom"
+	"net/http"
+	"strings"
+)
+
+/* sky coordinate reference system accepted in CRS and SRS parameters */
+type SkyCRS struct {
+	Name string
+	/* WMS 1.3.0 BBOX lists latitude before longitude */
+	LatFirst bool
+	/* converts to right ascension hours and declination degrees,
+	nil for rotated frames */
+	toHours func(x, y float64) (float64, float64)
+	/* rotated frame with x and y in degrees, nil if toHours is set */
+	frame *geom.Frame
+	/* frame is the horizon of the request observer, see Observer.crs() */
+	horizon bool
+}
+
+/* returns true if BBOX values are x, y in longitude degrees of a frame */
+func (c *SkyCRS) Rotated() bool {
+	return c.frame != nil
+}
+
+/* supported coordinate reference systems, native first */
+var crsRegistry = []*SkyCRS{
+	{Name: nativeCRS, toHours: func(x, y float64) (float64, float64) {
+		return x, y
+	}},
+	{Name: "STARMAP:EQUATORIAL-DEGREES",
+		toHours: func(x, y float64) (float64, float64) {
+			return x / geom.DegreesPerHour, y
+		}},
+	{Name: "STARMAP:GALACTIC", frame: geom.GalacticFrame},
+	{Name: "STARMAP:ECLIPTIC", frame: geom.EclipticFrame},
+	/* frame is set for each request from LAT, LON and TIME */
+	{Name: horizonCRS, horizon: true},
+	/* lon/lat clients see the sky from outside with 12h at longitude 0 */
+	{Name: "EPSG:4326", LatFirst: true, toHours: lonLatToHours},
+	{Name: "CRS:84", toHours: lonLatToHours},
+}
+
+/* converts longitude and latitude to right ascension hours and
+declination so lon/lat map clients can show the sky */
+func lonLatToHours(lon, lat float64) (float64, float64) {
+	return -lon/geom.DegreesPerHour + 12, lat
+}
+
+/* returns crs registered with name ignoring case, nil if not found */
+func findCRS(name string) *SkyCRS {
+	for _, c := range crsRegistry {
+		if strings.EqualFold(c.Name, name) {
+			return c
+		}
+	}
+	return nil
+}
+
+/* returns the names of all supported coordinate reference systems */
+func crsNames() []string {
+	rval := make([]string, len(crsRegistry))
+	for i, c := range crsRegistry {
+		rval[i] = c.Name
+	}
+	return rval
+}
+
+/* parse CRS for WMS 1.3.0 or SRS for earlier versions
+defaults to the native crs if neither is present, or the horizon crs
+of obs if it isn't nil */
+func crsParam(r *http.Request, obs *Observer) (*SkyCRS, error) {
+	key := "CRS"
+	name := r.FormValue(key)
+	if name == "" {
+		key = "SRS"
+		name = r.FormValue(key)
+	}
+	if name == "" {
+		name = nativeCRS
+		if obs != nil {
+			name = horizonCRS
+		}
+	}
+	rval := findCRS(name)
+	if rval == nil {
+		return nil, serviceErr(InvalidCRS, "Unsupported %v: %v", key, name)
+	}
+	if rval.horizon && obs == nil {
+		return nil, serviceErr(MissingParameterValue,
+			"%v %v needs LAT and LON", key, name)
+	} else if rval.horizon {
+		rval = obs.crs()
+	} else if obs != nil {
+		return nil, serviceErr(InvalidCRS,
+			"LAT and LON need %v %v: %v", key, horizonCRS, name)
+	}
+	return rval, nil
+}
@@ -0,0 +1,199 @@
+package starmap
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"net/http"
+	"render"
+	"strings"
+)
+
+/* OGC service exception codes */
+const (
+	InvalidFormat         = "InvalidFormat"
+	InvalidCRS            = "InvalidCRS"
+	InvalidSRS            = "InvalidSRS"
+	LayerNotDefined       = "LayerNotDefined"
+	StyleNotDefined       = "StyleNotDefined"
+	LayerNotQueryable     = "LayerNotQueryable"
+	InvalidPoint          = "InvalidPoint"
+	OperationNotSupported = "OperationNotSupported"
+	MissingParameterValue = "MissingParameterValue"
+	InvalidParameterValue = "InvalidParameterValue"
+	InvalidDimensionValue = "InvalidDimensionValue"
+	MissingDimensionValue = "MissingDimensionValue"
+	NoApplicableCode      = ""
+)
+
+/* exception formats, both WMS 1.1.1 and 1.3.0 names are accepted */
+const (
+	exceptions_xml      = "XML"
+	exceptions_inimage  = "INIMAGE"
+	exceptions_blank    = "BLANK"
+	exceptions_xml_111  = "application/vnd.ogc.se_xml"
+	exceptions_img_111  = "application/vnd.ogc.se_inimage"
+	exceptions_blank111 = "application/vnd.ogc.se_blank"
+)
+
+/* width in pixels of each character in label character map */
+const charWidth = 10
+
+/* error caused by a bad request, reported with an OGC exception code */
+type ServiceError struct {
+	Code string
+	Msg  string
+}
+
+/* see error interface */
+func (e *ServiceError) Error() string {
+	if e.Code == NoApplicableCode {
+		return e.Msg
+	}
+	return fmt.Sprintf("%v: %v", e.Code, e.Msg)
+}
+
+/* create a service error with code and formatted message */
+func serviceErr(code, format string, args ...interface{}) *ServiceError {
+	return &ServiceError{code, fmt.Sprintf(format, args...)}
+}
+
+/* xml service exception */
+type serviceException struct {
+	Code string `xml:"code,attr,omitempty"`
+	Msg  string `xml:",chardata"`
+}
+
+/* xml service exception report */
+type exceptionReport struct {
+	XMLName        xml.Name         `xml:"ServiceExceptionReport"`
+	Version        string           `xml:"version,attr"`
+	Xmlns          string           `xml:"xmlns,attr,omitempty"`
+	SchemaLocation string           `xml:"xsi:schemaLocation,attr,omitempty"`
+	XmlnsXsi       string           `xml:"xmlns:xsi,attr,omitempty"`
+	Exception      serviceException `xml:"ServiceException"`
+}
+
+/* send error response using the exception format named in the request */
+func doErr(w http.ResponseWriter, r *http.Request, err error) {
+	se, ok := err.(*ServiceError)
+	if !ok {
+		requestLogger(r).Errorf("%v", err)
+		se = &ServiceError{NoApplicableCode, err.Error()}
+	}
+	version := negotiateVersion(r.FormValue("VERSION"))
+	if version == wms_1_1_1 && se.Code == InvalidCRS {
+		se = &ServiceError{InvalidSRS, se.Msg}
+	}
+	format := strings.ToUpper(r.FormValue("EXCEPTIONS"))
+	switch format {
+	case exceptions_inimage, strings.ToUpper(exceptions_img_111):
+		writeExceptionImage(w, r, se, true)
+	case exceptions_blank, strings.ToUpper(exceptions_blank111):
+		writeExceptionImage(w, r, se, false)
+	default:
+		writeExceptionXML(w, se, version, ok)
+	}
+}
+
+/* send exception as OGC service exception report */
+func writeExceptionXML(w http.ResponseWriter, se *ServiceError,
+	version string, clientErr bool) {
+	report := &exceptionReport{Version: version,
+		Exception: serviceException{se.Code, se.Msg}}
+	var buff bytes.Buffer
+	buff.WriteString(xml.Header)
+	if version == wms_1_1_1 {
+		w.Header().Set("Content-Type", exceptions_xml_111)
+		buff.WriteString("<!DOCTYPE ServiceExceptionReport SYSTEM " +
+			"\"http://schemas.opengis.net/wms/1.1.1/exception_1_1_1.dtd\">\n")
+	} else {
+		w.Header().Set("Content-Type", "text/xml")
+		report.Xmlns = "http://www.opengis.net/ogc"
+		report.XmlnsXsi = "http://www.w3.org/2001/XMLSchema-instance"
+		report.SchemaLocation = "http://www.opengis.net/ogc " +
+			"http://schemas.opengis.net/wms/1.3.0/exceptions_1_3_0.xsd"
+	}
+	enc := xml.NewEncoder(&buff)
+	enc.Indent("", "   ")
+	if err := enc.Encode(report); err != nil {
+		http.Error(w, se.Error(), http.StatusInternalServerError)
+		return
+	}
+	if clientErr {
+		w.WriteHeader(http.StatusBadRequest)
+	} else {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	w.Write(buff.Bytes())
+}
+
+/* send exception as an image the size of the requested map,
+with the message drawn on it if showMsg is true */
+func writeExceptionImage(w http.ResponseWriter, r *http.Request,
+	se *ServiceError, showMsg bool) {
+	width, err := intParam("WIDTH", 1024, r)
+	if err != nil || width < 1 || width > maxWidth {
+		width = 1024
+	}
+	height, err := intParam("HEIGHT", 512, r)
+	if err != nil || height < 1 || height > maxHeight {
+		height = 512
+	}
+	img := render.CreateTransparent(width, height)
+	if showMsg && chars != nil {
+		cheight := chars.Bounds().Dy()
+		perLine := width / charWidth
+		p := image.Pt(0, 0)
+		for _, line := range wrapText(se.Error(), perLine) {
+			render.RenderString(img, chars, charWidth, &p, line, color.White)
+			p.Y += cheight
+		}
+	}
+	/* use requested format if possible */
+	format := findFormat(r.FormValue("FORMAT"))
+	if format == nil {
+		format = formatRegistry[0]
+	}
+	req := &Req{httpr: r, Width: width, Height: height, Quality: defaultQuality}
+	var buff bytes.Buffer
+	if err := format.encode(&buff, img, req); err != nil {
+		http.Error(w, se.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", format.ContentType)
+	w.Write(buff.Bytes())
+}
+
+/* break text into lines no longer than width characters */
+func wrapText(text string, width int) []string {
+	if width < 1 {
+		width = 1
+	}
+	rval := make([]string, 0, 4)
+	line := ""
+	for _, word := range strings.Fields(text) {
+		for len(word) > width {
+			if line != "" {
+				rval = append(rval, line)
+				line = ""
+			}
+			rval = append(rval, word[:width])
+			word = word[width:]
+		}
+		if line == "" {
+			line = word
+		} else if len(line)+1+len(word) <= width {
+			line += " " + word
+		} else {
+			rval = append(rval, line)
+			line = word
+		}
+	}
+	if line != "" {
+		rval = append(rval, line)
+	}
+	return rval
+}
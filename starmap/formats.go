@@ -0,0 +1,122 @@
+package starmap
+
+import (
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+)
+
+/* default jpeg quality when QUALITY isn't specified */
+const defaultQuality = 85
+
+/* get map output format */
+type Format struct {
+	MimeType string
+	/* value sent in Content-Type header */
+	ContentType string
+	/* true if format can store transparent pixels */
+	Alpha bool
+	/* writes img to w in format */
+	encode func(w io.Writer, img image.Image, req *Req) error
+}
+
+/* output formats in capabilities order, png is the default */
+var formatRegistry = []*Format{
+	&Format{"image/png", "image/png", true, encodePNG},
+	&Format{"image/png8", "image/png", true, encodePNG8},
+	&Format{"image/jpeg", "image/jpeg", false, encodeJPEG},
+	&Format{"image/gif", "image/gif", true, encodeGIF},
+}
+
+/* returns format registered under mime type or nil if not found */
+func findFormat(mimeType string) *Format {
+	for _, f := range formatRegistry {
+		if strings.EqualFold(f.MimeType, mimeType) {
+			return f
+		}
+	}
+	return nil
+}
+
+/* returns mime types of all registered formats */
+func mapFormats() []string {
+	rval := make([]string, len(formatRegistry))
+	for i, f := range formatRegistry {
+		rval[i] = f.MimeType
+	}
+	return rval
+}
+
+/* full color png */
+func encodePNG(w io.Writer, img image.Image, req *Req) error {
+	return png.Encode(w, img)
+}
+
+/* 8 bit paletted png, much smaller for mostly black star tiles */
+func encodePNG8(w io.Writer, img image.Image, req *Req) error {
+	return png.Encode(w, quantize(img))
+}
+
+/* jpeg using requested quality */
+func encodeJPEG(w io.Writer, img image.Image, req *Req) error {
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: req.Quality})
+}
+
+/* gif using the same palette as png8 */
+func encodeGIF(w io.Writer, img image.Image, req *Req) error {
+	return gif.Encode(w, quantize(img), nil)
+}
+
+/* palette used for 8 bit formats
+transparent, 216 web safe colors and the remaining entries as grays
+so star brightness survives quantization */
+var palette8 = createPalette8()
+
+/* build 8 bit palette, see palette8 */
+func createPalette8() color.Palette {
+	rval := make(color.Palette, 0, 256)
+	rval = append(rval, color.RGBA{0, 0, 0, 0})
+	for r := 0; r < 6; r += 1 {
+		for g := 0; g < 6; g += 1 {
+			for b := 0; b < 6; b += 1 {
+				rval = append(rval, color.RGBA{uint8(r * 51), uint8(g * 51),
+					uint8(b * 51), 255})
+			}
+		}
+	}
+	/* web safe colors only have 6 grays, spread the rest between them */
+	grays := cap(rval) - len(rval)
+	for i := 1; i <= grays; i += 1 {
+		g := uint8(i * 255 / (grays + 1))
+		rval = append(rval, color.RGBA{g, g, g, 255})
+	}
+	return rval
+}
+
+/* map img onto palette8, mostly transparent pixels become transparent */
+func quantize(img image.Image) *image.Paletted {
+	bounds := img.Bounds()
+	rval := image.NewPaletted(bounds, palette8)
+	/* tiles have few distinct colors so remember lookups */
+	indexes := make(map[color.RGBA]uint8)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += 1 {
+		for x := bounds.Min.X; x < bounds.Max.X; x += 1 {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			if c.A < 128 {
+				rval.SetColorIndex(x, y, 0)
+				continue
+			}
+			index, ok := indexes[c]
+			if !ok {
+				index = uint8(palette8[1:].Index(c) + 1)
+				indexes[c] = index
+			}
+			rval.SetColorIndex(x, y, index)
+		}
+	}
+	return rval
+}
@@ -0,0 +1,110 @@
+package starmap
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"text/template"
+)
+
+/* supported WMS versions, newest first */
+const (
+	wms_1_3_0 = "1.3.0"
+	wms_1_1_1 = "1.1.1"
+)
+
+/* capabilities templates keyed by WMS version */
+var capabilitiesTemplates = map[string]*template.Template{}
+
+/* capabilities content types keyed by WMS version */
+var capabilitiesTypes = map[string]string{
+	wms_1_3_0: "text/xml",
+	wms_1_1_1: "application/vnd.ogc.wms_xml",
+}
+
+/* native coordinate reference system, right ascension hours and
+declination degrees */
+const nativeCRS = "STARMAP:EQUATORIAL"
+
+/* struct used by capabilities templates to generate output */
+type capabilities struct {
+	Title       string
+	Abstract    string
+	URL         string
+	MaxWidth    int
+	MaxHeight   int
+	MapFormats  []string
+	InfoFormats []string
+	NativeCRS   string
+	CRS         []string
+	Layers      []*Layer
+}
+
+/* load capabilities templates for each supported version */
+func loadCapabilities(templateDir string) error {
+	for _, version := range []string{wms_1_3_0, wms_1_1_1} {
+		fname := "capabilities_" + strings.Replace(version, ".", "_", -1) +
+			".template"
+		t, err := template.ParseFiles(path.Join(templateDir, fname))
+		if err != nil {
+			return err
+		}
+		capabilitiesTemplates[version] = t
+	}
+	return nil
+}
+
+/* returns the newest supported version not greater than requested
+defaults to newest version if none requested */
+func negotiateVersion(requested string) string {
+	if requested == "" || requested >= wms_1_3_0 {
+		return wms_1_3_0
+	}
+	return wms_1_1_1
+}
+
+/* returns the service url for the request */
+func serviceURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%v://%v%v?", scheme, r.Host, r.URL.Path)
+}
+
+/* handler method for WMS get capabilities requests */
+func getcapabilities(w http.ResponseWriter, r *http.Request) {
+	version := negotiateVersion(r.FormValue("VERSION"))
+	t := capabilitiesTemplates[version]
+	if t == nil {
+		doErr(w, r, fmt.Errorf("No capabilities template for %v", version))
+		return
+	}
+	c := &capabilities{
+		Title:       "Starmap",
+		Abstract:    "Stars, constellations and asterisms of the night sky",
+		URL:         serviceURL(r),
+		MaxWidth:    maxWidth,
+		MaxHeight:   maxHeight,
+		MapFormats:  mapFormats(),
+		InfoFormats: infoFormats(),
+		NativeCRS:   nativeCRS,
+		CRS:         crsNames(),
+		Layers:      layerRegistry,
+	}
+	w.Header().Set("Content-Type", capabilitiesTypes[version])
+	if err := t.Execute(w, c); err != nil {
+		doErr(w, r, err)
+	}
+}
+
+/* returns true if list contains val ignoring case */
+func containsFold(list []string, val string) bool {
+	for _, s := range list {
+		if strings.EqualFold(s, val) {
+			return true
+		}
+	}
+	return false
+}
@@ -4,22 +4,33 @@ import (
 	"fmt"
 	"geom"
 	"image"
+	"math"
 	"net/http"
 	"strings"
 )
 
-const noDataFeatureInfo = "<html><body>no data</body></html>"
+/* limits for feature count and search tolerance in pixels */
+const (
+	maxFeatureCount  = 100
+	defaultTolerance = 10
+	maxTolerance     = 100
+)
 
 /* feature parameter key/val pair */
 type Param struct {
 	Key string
+	/* value as displayed in html and text output */
 	Val string
+	/* typed value used in json output */
+	Value interface{}
 }
 
-/* struct used by template to generate output */
+/* struct used by info formats to generate output */
 type Feature struct {
 	Name   string
 	Params []Param
+	/* location of feature, may be nil */
+	Geom geom.Geometry
 }
 
 /* convert a contellation object into feature parameters */
@@ -30,71 +41,198 @@ func constAsParams(constel *Constellation) []Param {
 	return rval
 }
 
-/* takes in a star and converts it to a parameter slice */
-func asParams(star *Star) []Param {
-	rval := make([]Param, 0, 6)
-	rval = addParam(rval, "hipparcos #", fmt.Sprintf("%v", star.HipNum))
-	rval = addParam(rval, "name", star.Name)
-	rval = addParam(rval, "magnitude", fmt.Sprintf("%v", star.Magnitude))
-	coord, err := geom.UnHash(star.GeoHash, geom.STELLAR)
+/* takes in a star and julian date of its position
+converts it to a parameter slice */
+func asParams(star *Star, epoch float64) []Param {
+	rval := make([]Param, 0, 10)
+	rval = addParam(rval, "hipparcos #", int(star.HipNum))
+	if star.Name != "" {
+		rval = addParam(rval, "name", star.Name)
+	}
+	rval = addParam(rval, "magnitude", star.Magnitude)
+	coord, err := star.Position(epoch)
 	if err == nil {
-		rval = addParam(rval, "right ascension",
-			fmt.Sprintf("%0.5f", coord.X()))
-		rval = addParam(rval, "declination", fmt.Sprintf("%0.5f", coord.Y()))
+		rval = addCoordParam(rval, "right ascension", coord.X())
+		rval = addCoordParam(rval, "declination", coord.Y())
+	}
+	if star.Moves() {
+		rval = addParam(rval, "proper motion ra", star.PMRA)
+		rval = addParam(rval, "proper motion dec", star.PMDec)
+		rval = addParam(rval, "parallax", star.Parallax)
+		rval = addParam(rval, "radial velocity", star.RadialVelocity)
 	}
 	return rval
 }
 
 /* create parameter object and append to dest */
-func addParam(dest []Param, key, val string) []Param {
-	return append(dest, Param{key, val})
+func addParam(dest []Param, key string, value interface{}) []Param {
+	return append(dest, Param{key, fmt.Sprintf("%v", value), value})
+}
+
+/* create parameter object for coordinate value rounded to
+5 decimal places and append to dest */
+func addCoordParam(dest []Param, key string, value float64) []Param {
+	return append(dest, Param{key, fmt.Sprintf("%0.5f", value),
+		roundCoord(value)})
+}
+
+/* returns value rounded to 5 decimal places */
+func roundCoord(value float64) float64 {
+	return math.Floor(value*1e5+0.5) / 1e5
 }
 
 /* handler method for WMS get feature info requests */
 func getfeatureinfo(w http.ResponseWriter, r *http.Request) {
 	if templateErr != nil {
-		doErr(w, templateErr)
+		doErr(w, r, templateErr)
+		return
+	}
+	req, err := ParseReq(r)
+	if err != nil {
+		doErr(w, r, err)
+		return
+	}
+	infoFormat := findInfoFormat(strParam("INFO_FORMAT", "text/html", r))
+	if infoFormat == nil {
+		doErr(w, r, serviceErr(InvalidFormat, "Unsupported INFO_FORMAT: %v",
+			r.FormValue("INFO_FORMAT")))
+		return
+	}
+	i, j, err := parseQueryPoint(req)
+	if err != nil {
+		doErr(w, r, err)
+		return
+	}
+	count, err := sizeParam("FEATURE_COUNT", 1, maxFeatureCount, r)
+	if err != nil {
+		doErr(w, r, err)
+		return
+	}
+	tolerance, err := sizeParam("TOLERANCE", defaultTolerance, maxTolerance, r)
+	if err != nil {
+		doErr(w, r, err)
+		return
+	}
+	queryLayers, err := queryLayersParam(req)
+	if err != nil {
+		doErr(w, r, err)
 		return
 	}
-	req := ParseReq(r)
-	i := intParam("X", 0, r)
-	j := intParam("Y", 0, r)
 	trans := req.Trans(geom.STELLAR)
 	coord := trans.Reverse(&image.Point{i, j})
-	layers := strings.Split(req.Layer, ",")
 	features := make([]*Feature, 0, 3)
-    asters := false
-	for _, layer := range layers {
-		if layer == "stars" {
-			sf := starFeatures(req, coord)
-			features = append(features, sf...)
-		} else if layer == "constellations" {
-			cf := constelFeatures(coord, asters)
-			features = append(features, cf...)
-		} else if layer == "asterisms" {
-            asters = true
-        }
-	}
-	if len(features) > 0 {
-		err := featureTemplate.Execute(w, features)
-		if err != nil {
-			doErr(w, err)
+	asters := false
+	/* pixels outside of a projection have no features */
+	if coord != nil {
+		/* requests that wrap across 0h can have coordinates outside the sky */
+		coord = geom.NewPoint2D(geom.STELLAR.WrapX(coord.X()), coord.Y())
+		for _, layer := range queryLayers {
+			if layer == "stars" {
+				sf := starFeatures(req, coord, trans, tolerance, count)
+				features = append(features, sf...)
+			} else if layer == "constellations" {
+				cf := constelFeatures(coord, asters)
+				features = append(features, cf...)
+			} else if layer == "asterisms" {
+				asters = true
+			} else if layer == "planets" {
+				pf := planetFeatures(req, coord, trans, tolerance, count)
+				features = append(features, pf...)
+			} else if layer == "references" {
+				rf := referenceFeatures(coord,
+					pixelDegrees(trans, float64(tolerance)))
+				features = append(features, rf...)
+			}
 		}
-	} else {
-		w.Write([]byte(noDataFeatureInfo))
 	}
+	w.Header().Set("Content-Type", infoFormat.ContentType)
+	if err := infoFormat.write(w, features); err != nil {
+		doErr(w, r, err)
+	}
+}
+
+/*
+parse comma separated QUERY_LAYERS, defaults to the queryable layers of
+LAYERS. layers are returned with their registered names.
+error if any layer isn't in LAYERS or can't be queried
+*/
+func queryLayersParam(req *Req) ([]string, error) {
+	value := req.httpr.FormValue("QUERY_LAYERS")
+	if value == "" {
+		rval := make([]string, 0, len(req.Layers))
+		for _, name := range req.Layers {
+			if findLayer(name).Queryable {
+				rval = append(rval, name)
+			}
+		}
+		return rval, nil
+	}
+	names := strings.Split(value, ",")
+	rval := make([]string, len(names))
+	for i, name := range names {
+		layer := findLayer(name)
+		requested := false
+		for _, l := range req.Layers {
+			requested = requested || (layer != nil && l == layer.Name)
+		}
+		if !requested {
+			return nil, serviceErr(LayerNotDefined,
+				"QUERY_LAYERS must be listed in LAYERS: %v", name)
+		}
+		if !layer.Queryable {
+			return nil, serviceErr(LayerNotQueryable,
+				"Layer can't be queried: %v", name)
+		}
+		rval[i] = layer.Name
+	}
+	return rval, nil
 }
 
-/* get star layer feature info for point */
-func starFeatures(req *Req, point *geom.Point) []*Feature {
-	sr := &StarReq{req, make(chan Stardata)}
-	starReqChan <- sr
-	star := FindClosest(sr, point)
-	if star != nil {
-		return []*Feature{&Feature{"star", asParams(star)}}
-	} else {
-		return []*Feature{}
+/* parse query pixel from X and Y, or I and J for WMS 1.3.0
+error if missing or outside of the requested image */
+func parseQueryPoint(req *Req) (int, int, error) {
+	xkey, ykey := "X", "Y"
+	if req.httpr.FormValue("I") != "" || req.httpr.FormValue("J") != "" {
+		xkey, ykey = "I", "J"
 	}
+	if req.httpr.FormValue(xkey) == "" || req.httpr.FormValue(ykey) == "" {
+		return 0, 0, serviceErr(MissingParameterValue,
+			"%v and %v are required", xkey, ykey)
+	}
+	i, errX := intParam(xkey, 0, req.httpr)
+	j, errY := intParam(ykey, 0, req.httpr)
+	if errX != nil || errY != nil || i < 0 || i >= req.Width ||
+		j < 0 || j >= req.Height {
+		return 0, 0, serviceErr(InvalidPoint, "Invalid query point %v, %v",
+			req.httpr.FormValue(xkey), req.httpr.FormValue(ykey))
+	}
+	return i, j, nil
+}
+
+/* takes in transform used to draw a map and a distance in pixels
+returns the distance in degrees. vertical pixels have the same angular
+size everywhere, horizontal pixels shrink toward the poles */
+func pixelDegrees(trans *geom.PointTransform, pixels float64) float64 {
+	return pixels * trans.Dy
+}
+
+/* get star layer feature info for up to count stars within
+tolerance pixels of point */
+func starFeatures(req *Req, point *geom.Point, trans *geom.PointTransform,
+	tolerance, count int) []*Feature {
+	tiers := catalog.Tiers(requestLogger(req.httpr), levels(req))
+	/* only stars GetMap draws */
+	drawn := func(s *Star, coord *geom.Point) bool {
+		return req.starMagnitude(s, coord) <= req.MaxMag
+	}
+	stars := findWithin(tiers, point, pixelDegrees(trans, float64(tolerance)),
+		count, req.Epoch, drawn)
+	rval := make([]*Feature, len(stars))
+	for i, star := range stars {
+		coord, _ := star.Position(req.Epoch)
+		rval[i] = &Feature{"star", asParams(star, req.Epoch), coord}
+	}
+	return rval
 }
 
 /* get contellation layer feature info for point */
@@ -103,13 +241,13 @@ func constelFeatures(point *geom.Point, asters bool) []*Feature {
 	for _, c := range constelData {
 		for _, pi := range c.PolyInfos {
 			if pi.Geom.Contains(point) {
-                params := constAsParams(c)
-                if asters {
-                    for _, si := range c.StringInfos {
-                        params = addParam(params, "asterism", si.Name)
-                    }
-                }
-                f := &Feature{"constellation", params}
+				params := constAsParams(c)
+				if asters {
+					for _, si := range c.StringInfos {
+						params = addParam(params, "asterism", si.Name)
+					}
+				}
+				f := &Feature{"constellation", params, pi.Geom}
 				rval = append(rval, f)
 			}
 		}
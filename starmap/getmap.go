@@ -1,17 +1,17 @@
 package starmap
 
 import (
-	"appengine"
-	"appengine/memcache"
 	"bytes"
 	"fmt"
 	"geom"
 	"image/color"
-	"image/png"
+	"image/draw"
+	"math"
 	"net/http"
 	"render"
 	"render/style"
 	"strings"
+	"time"
 )
 
 var smlCircle = style.NewPointStyle(0.5, color.White, style.CIRCLE)
@@ -19,6 +19,9 @@ var midCircle = style.NewPointStyle(1, color.White, style.CIRCLE)
 var lrgCircle = style.NewPointStyle(2, color.White, style.CIRCLE)
 var superCircle = style.NewPointStyle(3, color.White, style.CIRCLE)
 
+/* pixels past tile edges to look for stars that are drawn into the tile */
+const starMargin = 4
+
 var labelColors = map[string]color.Color{
 	"Heavenly Waters": color.RGBA{0, 154, 205, 255},
 	"Hercules":        color.RGBA{34, 139, 34, 255},
@@ -30,151 +33,231 @@ var labelColors = map[string]color.Color{
 }
 
 /* create the cache key for a WMS tile */
-func createKey(r *Req) string {
-	return fmt.Sprintf("%v-%v-%v-%v-%v", r.Layer, r.Width, r.Height,
-		r.Lower, r.Upper)
+func createKey(r *Req) *TileKey {
+	bbox := fmt.Sprintf("%v,%v,%v,%v", r.Lower.X(), r.Lower.Y(),
+		r.Upper.X(), r.Upper.Y())
+	format := strings.ToLower(r.Format)
+	if format == "image/jpeg" {
+		format = fmt.Sprintf("%v;quality=%v", format, r.Quality)
+	}
+	options := fmt.Sprintf("style=%v;format=%v;transparent=%v;bgcolor=%v",
+		strings.Join(r.Styles, ","), format, r.Transparent, r.BGColor)
+	if r.CRS != nil && r.CRS.Rotated() {
+		options += ";crs=" + r.CRS.Name
+	}
+	if r.Projection != "" {
+		options += ";projection=" + r.Projection
+	}
+	if r.Epoch != CatalogEpoch {
+		options += fmt.Sprintf(";epoch=%v", r.Epoch)
+	}
+	if r.Observer != nil {
+		options += ";observer=" + r.Observer.String()
+	}
+	if !math.IsInf(r.MaxMag, 1) {
+		options += fmt.Sprintf(";maxmag=%v", r.MaxMag)
+	}
+	if r.Observer == nil && r.hasLayer("planets") {
+		options += ";time=" + r.Time.Format(time.RFC3339)
+	}
+	layers := strings.Join(r.Layers, ",")
+	return &TileKey{layers, r.Width, r.Height, bbox, options}
 }
 
 /* WMS getmap handler function */
 func getmap(w http.ResponseWriter, r *http.Request) {
-	req := ParseReq(r)
-	ctx := appengine.NewContext(r)
+	req, err := ParseReq(r)
+	if err != nil {
+		doErr(w, r, err)
+		return
+	}
+	format := findFormat(req.Format)
+	if format == nil {
+		doErr(w, r, serviceErr(InvalidFormat, "Unsupported FORMAT: %v",
+			req.Format))
+		return
+	}
+	if !format.Alpha {
+		req.Transparent = false
+	}
 	cacheKey := createKey(req)
-	item, err := memcache.Get(ctx, cacheKey)
-	if err == memcache.ErrCacheMiss {
-		tile, err := createTile(w, req)
+	tile, err := tiles.Get(r, cacheKey)
+	if err != nil && err != ErrCacheMiss {
+		/* a broken cache shouldn't fail the request either */
+		requestLogger(r).Errorf("Unable to read cached %v: %v", cacheKey, err)
+	}
+	if err != nil {
+		tile, err = createTile(req, format)
 		if err != nil {
-			doErr(w, err)
+			doErr(w, r, err)
 			return
 		}
-		item = &memcache.Item{Key: cacheKey, Value: tile}
-		err = memcache.Add(ctx, item)
-		if err != nil {
-			doErr(w, err)
-			return
+		/* failing to cache shouldn't fail the request */
+		if err = tiles.Add(r, cacheKey, tile); err != nil {
+			requestLogger(r).Errorf("Unable to cache %v: %v", cacheKey, err)
 		}
-	} else if err != nil {
-		doErr(w, err)
-		return
 	}
-
-	w.Write(item.Value)
+	w.Header().Set("Content-Type", format.ContentType)
+	w.Write(tile)
 }
 
-/* create a new tile image for request */
-func createTile(w http.ResponseWriter, req *Req) ([]byte, error) {
-	layer := strings.ToLower(req.Layer)
-	if layer == "constellations" {
-		return createConstTile(w, req)
-	} else if layer == "asterisms" {
-		return createAsterTile(w, req)
+/* create a new tile for request encoded in format
+layers are drawn in request order onto a single image */
+func createTile(req *Req, format *Format) ([]byte, error) {
+	var img draw.Image
+	if req.Transparent {
+		img = render.CreateTransparent(req.Width, req.Height)
 	} else {
-		return createStarTile(w, req)
+		img = render.Create(req.Width, req.Height, req.BGColor)
+	}
+	for _, name := range req.Layers {
+		layer := findLayer(name)
+		if layer == nil {
+			return nil, serviceErr(LayerNotDefined, "Unknown layer: %v", name)
+		}
+		if err := layer.draw(img, req); err != nil {
+			return nil, err
+		}
 	}
+	if req.Observer != nil {
+		drawHorizon(img, req)
+	}
+	var rval bytes.Buffer
+	if err := format.encode(&rval, img, req); err != nil {
+		return nil, err
+	}
+	return rval.Bytes(), nil
 }
 
-/* create a constellation layer tile */
-func createConstTile(w http.ResponseWriter, req *Req) ([]byte, error) {
+/* draw constellation boundaries and labels onto img
+boundaries are fixed on the sky so they don't move with the request epoch */
+func createConstTile(img draw.Image, req *Req) error {
 	if constelErr != nil {
-		return nil, constelErr
+		return constelErr
 	}
 	scale := req.Scale()
 	s := style.NewPolyStyle(1, color.White)
-	trans := req.Trans(geom.STELLAR)
-	img := render.CreateTransparent(req.Width, req.Height)
-	bbox := req.BBox()
-	for _, c := range constelData {
-		txtColor := labelColors[c.Family]
-		if txtColor == nil {
-			txtColor = color.White
-		}
-		for _, pi := range c.PolyInfos {
-			if bbox.Touches(pi.Geom) {
-				render.RenderPoly(img, pi.Geom, trans, s)
-				if charsErr == nil && pi.LabelPoint != nil &&
-					pi.MaxScale > scale {
-					labelPoint := pi.LabelPoint
-					pix := trans.TransformXY(labelPoint[0], labelPoint[1])
-					render.RenderString(img, chars, 10, pix, c.Name, txtColor)
+	for _, part := range req.Parts() {
+		trans := req.PartTrans(part, geom.STELLAR)
+		for _, c := range constelData {
+			txtColor := labelColors[c.Family]
+			if txtColor == nil {
+				txtColor = color.White
+			}
+			for _, pi := range c.PolyInfos {
+				if part.Touches(pi.Geom) {
+					render.RenderPoly(img, pi.Geom, trans, s)
+					if charsErr == nil && pi.LabelPoint != nil &&
+						pi.MaxScale > scale {
+						labelPoint := pi.LabelPoint
+						pix, ok := trans.TransformVisible(labelPoint[0],
+							labelPoint[1])
+						if ok {
+							render.RenderString(img, chars, charWidth, pix,
+								c.Name, txtColor)
+						}
+					}
 				}
 			}
 		}
 	}
-	var rval bytes.Buffer
-	if err := png.Encode(&rval, img); err != nil {
-		return nil, err
-	}
-	return rval.Bytes(), nil
+	return nil
 }
 
-func createAsterTile(w http.ResponseWriter, req *Req) ([]byte, error) {
+/* draw asterism lines onto img */
+func createAsterTile(img draw.Image, req *Req) error {
 	if constelErr != nil {
-		return nil, constelErr
+		return constelErr
 	}
 	s := style.NewPolyStyle(1, color.White)
-	trans := req.Trans(geom.STELLAR)
-	img := render.CreateTransparent(req.Width, req.Height)
-	bbox := req.BBox()
-	for _, c := range constelData {
-		for _, si := range c.StringInfos {
-			for _, cs := range si.Lines {
-				if bbox.TouchesSeq(cs) {
-					render.RenderSeq(img, cs, trans, s)
+	for _, part := range req.Parts() {
+		trans := req.PartTrans(part, geom.STELLAR)
+		for _, c := range constelData {
+			for _, si := range c.StringInfos {
+				for _, cs := range si.Lines {
+					if part.TouchesSeq(cs) {
+						render.RenderSeq(img, cs, trans, s)
+					}
 				}
 			}
 		}
 	}
-	var rval bytes.Buffer
-	if err := png.Encode(&rval, img); err != nil {
-		return nil, err
-	}
-	return rval.Bytes(), nil
+	return nil
 }
 
-/* create a star layer tile */
-func createStarTile(w http.ResponseWriter, req *Req) ([]byte, error) {
-	lowerHash, upperHash := geom.BBoxHash(req.Lower, req.Upper, geom.STELLAR)
+/* draw stars onto img */
+func createStarTile(img draw.Image, req *Req) error {
+	tiers := catalog.Tiers(requestLogger(req.httpr), levels(req))
+	bbox := req.BBox()
 	trans := req.Trans(geom.STELLAR)
-	img := render.Create(req.Width, req.Height, color.Black)
-
-	sr := &StarReq{req, make(chan Stardata)}
-	starReqChan <- sr
-	for data := range sr.out {
-		stars := data.Range(lowerHash, upperHash)
-		for _, s := range stars {
-			coord, err := geom.UnHash(s.GeoHash, geom.STELLAR)
+	centerx := (bbox.Lower().X() + bbox.Upper().X()) / 2
+	for _, tier := range tiers {
+		/* include stars that move into the tile by the requested epoch */
+		moved := tier.motionBounds(bbox, req.Epoch)
+		for _, s := range tier.Stars.Query(moved, trans, starMargin) {
+			coord, err := s.Position(req.Epoch)
 			if err != nil {
-				return nil, err
+				return err
 			}
-			pix := trans.Transform(coord)
-			mag := s.Magnitude
-			style := smlCircle
-			var gray uint8
-			if mag < -1 {
-				style = superCircle
-				gray = 255
-			} else if mag < 0 {
-				style = superCircle
-				gray = 200
-			} else if mag < 2 {
-				style = lrgCircle
-				gray = uint8((2.0-mag)*64.0) + 128
-			} else if mag < 4 {
-				style = midCircle
-				gray = uint8((4.0-mag)*64.0) + 128
-			} else if mag < 20 {
-				gray = uint8((20.0-mag)*12.0) + 64
-			} else {
-				gray = 64
+			mag := req.starMagnitude(s, coord)
+			if mag > req.MaxMag {
+				continue
 			}
-			color := color.RGBA{gray, gray, gray, 255}
-			style.Style.Color = color
-			render.Render(img, pix, style)
+			/* stars across 0h from the center of the tile */
+			x := geom.STELLAR.NearestX(coord.X(), centerx)
+			drawStar(img, geom.NewPoint2D(x, coord.Y()), trans, mag,
+				req.MaxMag)
 		}
 	}
-	var rval bytes.Buffer
-	if err := png.Encode(&rval, img); err != nil {
-		return nil, err
+	return nil
+}
+
+/* look of stars with magnitudes up to faint, gray fades from bright to
+dim across the bracket */
+type starBracket struct {
+	faint  float64
+	style  *style.PointStyle
+	bright float64
+	dim    float64
+}
+
+/* star looks from brightest to faintest */
+var starBrackets = []starBracket{
+	{-1, superCircle, 255, 255},
+	{0, superCircle, 200, 200},
+	{2, lrgCircle, 256, 128},
+	{4, midCircle, 256, 128},
+	{20, smlCircle, 256, minGray},
+}
+
+/*
+draw star with magnitude mag at coord, brighter stars are larger.
+faintest is the faintest magnitude drawn, the bracket it falls in is
+stretched so it fades to the darkest gray. +Inf to keep the brackets
+*/
+func drawStar(img draw.Image, coord *geom.Point, trans *geom.PointTransform,
+	mag, faintest float64) {
+	style := smlCircle
+	gray := float64(minGray)
+	brighter := math.Inf(-1)
+	for _, b := range starBrackets {
+		if mag >= b.faint {
+			brighter = b.faint
+			continue
+		}
+		style, gray = b.style, b.bright
+		faint, dim := b.faint, b.dim
+		if faintest < faint && b.bright != b.dim {
+			faint, dim = faintest, minGray
+		}
+		if !math.IsInf(brighter, -1) {
+			gray = dim + (faint-mag)/(faint-brighter)*(b.bright-dim)
+		}
+		break
 	}
-	return rval.Bytes(), nil
+	level := uint8(math.Max(math.Min(gray, 255), 0))
+	/* copy shared style since tiles render concurrently */
+	starStyle := *style
+	starStyle.Style.Color = color.RGBA{level, level, level, 255}
+	render.RenderPoint(img, coord, trans, &starStyle)
 }
@@ -0,0 +1,348 @@
+package starmap
+
+import (
+	"fmt"
+	"geom"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"render"
+	"render/style"
+	"strings"
+)
+
+/* grid line spacings in degrees, smallest first */
+var degreeSpacings = []float64{1.0 / 60, 2.0 / 60, 5.0 / 60, 10.0 / 60,
+	15.0 / 60, 0.5, 1, 2, 5, 10, 15, 30}
+
+/* right ascension grid line spacings in hours, smallest first */
+var hourSpacings = []float64{1.0 / 60, 2.0 / 60, 5.0 / 60, 10.0 / 60,
+	15.0 / 60, 0.5, 1, 2, 3, 6}
+
+/* grid lines are at least this many pixels apart */
+const gridPixels = 100
+
+/* most lines drawn in each direction, spacing grows near the poles where
+the bounds cover much more than the image */
+const maxGridLines = 72
+
+/* most points in a grid line from another frame */
+const maxGridSamples = 2048
+
+/* largest gap in degrees and pixels between points of grid lines in
+other frames */
+const (
+	gridSampleDegrees = 1.0
+	gridSamplePixels  = 4.0
+)
+
+/* coordinate grid drawn for a grid layer style */
+type gridDef struct {
+	/* nil for equatorial coordinates */
+	frame      *geom.Frame
+	labelColor color.Color
+}
+
+/* grids by layer style name */
+var gridDefs = map[string]*gridDef{
+	defaultStyle.Name: &gridDef{nil, color.RGBA{128, 160, 255, 255}},
+	"galactic": &gridDef{geom.GalacticFrame,
+		color.RGBA{255, 160, 96, 255}},
+	"ecliptic": &gridDef{geom.EclipticFrame,
+		color.RGBA{255, 224, 96, 255}},
+}
+
+/* grid line in equatorial coordinates and its label */
+type gridLine struct {
+	coords *geom.CoordinateSeq
+	label  string
+}
+
+/*
+takes in range, spacings, smallest first, and coordinate units per pixel
+returns multiples of the smallest spacing at least gridPixels apart that
+has no more than maxGridLines in range, and that spacing
+*/
+func gridSteps(min, max float64, spacings []float64,
+	perPixel float64) ([]float64, float64) {
+	var rval []float64
+	var step float64
+	for _, step = range spacings {
+		if step/perPixel < gridPixels {
+			continue
+		}
+		rval = steps(min, max, step)
+		if len(rval) <= maxGridLines {
+			break
+		}
+	}
+	if rval == nil {
+		rval = steps(min, max, step)
+	}
+	return rval, step
+}
+
+/* draw coordinate grid lines and labels onto img */
+func createGridTile(img draw.Image, req *Req) error {
+	grid := gridDefs[strings.ToLower(req.layerStyle("grid"))]
+	if grid == nil {
+		grid = gridDefs[defaultStyle.Name]
+	}
+	trans := req.Trans(geom.STELLAR)
+	bbox := req.BBox()
+	var lines []*gridLine
+	/* right ascension spacing follows the scale, other spacing follows
+	angular size which doesn't shrink near the poles */
+	if grid.frame == nil {
+		lines = equatorialGrid(bbox, req.Scale(), trans.Dy)
+	} else {
+		lines = frameGrid(grid.frame, bbox, trans.Dy)
+	}
+	s := style.NewPolyStyle(1, grid.labelColor)
+	shifts := []float64{0}
+	if grid.frame != nil {
+		shifts = seamShifts(trans)
+	}
+	for _, line := range lines {
+		for _, shift := range shifts {
+			coords := line.coords
+			if shift != 0 {
+				coords = shiftSeq(coords, shift)
+			}
+			render.RenderSeq(img, coords, trans, s)
+			if charsErr == nil {
+				labelLine(img, coords, line.label, trans, grid.labelColor)
+			}
+		}
+	}
+	return nil
+}
+
+/*
+takes in bounds in hours and degrees, scale in hours per pixel and
+degrees per pixel
+returns right ascension and declination lines across bounds
+*/
+func equatorialGrid(bbox *geom.BoundingBox, scale,
+	degPerPixel float64) []*gridLine {
+	lower, upper := bbox.Lower(), bbox.Upper()
+	xs, xstep := gridSteps(lower.X(), upper.X(), hourSpacings, scale)
+	ys, ystep := gridSteps(lower.Y(), upper.Y(), degreeSpacings,
+		degPerPixel)
+	min, max := geom.STELLAR.XRange()
+	rval := make([]*gridLine, 0, len(xs)+len(ys))
+	for _, x := range xs {
+		label := formatHours(x, xstep)
+		/* don't mistake 0h for a shape cut at the grid edge,
+		see PointTransform.OnSeam() */
+		if x == min {
+			x += 1e-9
+		} else if x == max {
+			x -= 1e-9
+		}
+		coords := []float64{x, lower.Y(), x, upper.Y()}
+		cs := &geom.CoordinateSeq{Coords: coords, Dims: 2}
+		rval = append(rval, &gridLine{cs, label})
+	}
+	for _, y := range ys {
+		if math.Abs(y) >= 90 {
+			continue
+		}
+		coords := []float64{lower.X(), y, upper.X(), y}
+		cs := &geom.CoordinateSeq{Coords: coords, Dims: 2}
+		rval = append(rval, &gridLine{cs, formatLatitude(y, ystep)})
+	}
+	return rval
+}
+
+/*
+takes in frame, bounds in hours and degrees and degrees per pixel
+returns longitude and latitude lines of frame across bounds
+in equatorial coordinates
+*/
+func frameGrid(frame *geom.Frame, bbox *geom.BoundingBox,
+	degPerPixel float64) []*gridLine {
+	fb := geom.FrameBounds(frame, bbox)
+	lower, upper := fb.Lower(), fb.Upper()
+	xs, xstep := gridSteps(lower.X(), upper.X(), degreeSpacings, degPerPixel)
+	ys, ystep := gridSteps(lower.Y(), upper.Y(), degreeSpacings, degPerPixel)
+	sample := math.Min(math.Min(xstep, ystep)/4, gridSampleDegrees)
+	sample = math.Min(sample, gridSamplePixels*degPerPixel)
+	centerx := (bbox.Lower().X() + bbox.Upper().X()) / 2
+	rval := make([]*gridLine, 0, len(xs)+len(ys))
+	for _, x := range xs {
+		cs := frameLine(frame, x, lower.Y(), x, upper.Y(), sample, centerx)
+		rval = append(rval, &gridLine{cs, formatLongitude(x, xstep)})
+	}
+	for _, y := range ys {
+		if math.Abs(y) >= 90 {
+			continue
+		}
+		cs := frameLine(frame, lower.X(), y, upper.X(), y, sample, centerx)
+		rval = append(rval, &gridLine{cs, formatLatitude(y, ystep)})
+	}
+	return rval
+}
+
+/*
+takes in frame, a straight line in frame degrees and the largest gap
+between points in degrees
+returns line in equatorial coordinates with right ascension continuous
+from near centerx
+*/
+func frameLine(frame *geom.Frame, x0, y0, x1, y1, sample,
+	centerx float64) *geom.CoordinateSeq {
+	n := int(math.Ceil(math.Max(math.Abs(x1-x0), math.Abs(y1-y0)) / sample))
+	n = clampInt(n, 1, maxGridSamples)
+	coords := make([]float64, 0, 2*(n+1))
+	prevx := centerx
+	for i := 0; i <= n; i += 1 {
+		s := float64(i) / float64(n)
+		p := geom.NewPoint2D(x0+(x1-x0)*s, y0+(y1-y0)*s).FromFrame(frame)
+		x := geom.STELLAR.NearestX(p.X(), prevx)
+		coords = append(coords, x, p.Y())
+		prevx = x
+	}
+	return &geom.CoordinateSeq{Coords: coords, Dims: 2}
+}
+
+/* returns multiples of step from min to max */
+func steps(min, max, step float64) []float64 {
+	rval := make([]float64, 0, 16)
+	for k := math.Ceil(min / step); k*step <= max; k += 1 {
+		rval = append(rval, k*step)
+	}
+	return rval
+}
+
+/* returns right ascension offsets to draw lines from other frames at,
+they may cross 0h outside of linear tiles */
+func seamShifts(trans *geom.PointTransform) []float64 {
+	if trans.Projected() {
+		return []float64{0}
+	}
+	return []float64{-24, 0, 24}
+}
+
+/* returns copy of coordinate sequence moved by dx */
+func shiftSeq(cs *geom.CoordinateSeq, dx float64) *geom.CoordinateSeq {
+	coords := make([]float64, len(cs.Coords))
+	for i := 0; i < len(coords); i += 2 {
+		coords[i] = cs.Coords[i] + dx
+		coords[i+1] = cs.Coords[i+1]
+	}
+	return &geom.CoordinateSeq{Coords: coords, Dims: 2}
+}
+
+/*
+draw label where line first enters the image, or at the visible point
+nearest the middle of line if it starts inside the image and never enters.
+label is moved inside the image so it isn't cut off at the edge
+*/
+func labelLine(img draw.Image, cs *geom.CoordinateSeq, label string,
+	trans *geom.PointTransform, c color.Color) {
+	bounds := img.Bounds()
+	outside := bounds
+	if trans.Projected() {
+		/* lines can end right on the edge of a projection fit to the image */
+		outside = bounds.Inset(-2)
+	}
+	step := math.Min(trans.Dy*gridPixels/8, gridSampleDegrees)
+	/* visible point nearest the middle of the line */
+	var middle *image.Point
+	middleDist := math.Inf(1)
+	segments := float64(cs.Len() - 1)
+	wasOutside := false
+	for i := 1; i < cs.Len(); i += 1 {
+		pts := trans.Densify(cs.Get(i-1), cs.Get(i), step)
+		for j, pt := range pts {
+			pix, ok := trans.TransformVisible(pt[0], pt[1])
+			if !ok || !pix.In(outside) {
+				wasOutside = true
+				continue
+			} else if !pix.In(bounds) {
+				continue
+			}
+			if wasOutside {
+				drawLabel(img, pix, label, c)
+				return
+			}
+			/* fraction of the way along the line */
+			f := (float64(i-1) + float64(j)/float64(len(pts)-1)) / segments
+			if dist := math.Abs(f - 0.5); dist < middleDist {
+				middle, middleDist = pix, dist
+			}
+		}
+	}
+	if middle != nil {
+		drawLabel(img, middle, label, c)
+	}
+}
+
+/* draw label at pix moved inside of img */
+func drawLabel(img draw.Image, pix *image.Point, label string, c color.Color) {
+	bounds := img.Bounds()
+	cbounds := chars.Bounds()
+	width := len(label) * charWidth
+	height := cbounds.Max.Y - cbounds.Min.Y
+	x := clampInt(pix.X, bounds.Min.X, bounds.Max.X-width)
+	y := clampInt(pix.Y, bounds.Min.Y, bounds.Max.Y-height)
+	render.RenderString(img, chars, charWidth, &image.Point{x, y}, label, c)
+}
+
+/* returns v moved inside of [min, max] */
+func clampInt(v, min, max int) int {
+	if v > max {
+		v = max
+	}
+	if v < min {
+		v = min
+	}
+	return v
+}
+
+/* takes in degrees returns rounded whole degrees and minutes */
+func degreesMinutes(deg float64) (int, int) {
+	minutes := int(math.Floor(math.Abs(deg)*60 + 0.5))
+	return minutes / 60, minutes % 60
+}
+
+/* format right ascension as hours, with minutes if step is smaller than
+an hour */
+func formatHours(ra, step float64) string {
+	h, m := degreesMinutes(geom.STELLAR.WrapX(ra))
+	h %= 24
+	if step >= 1 {
+		return fmt.Sprintf("%dh", h)
+	}
+	return fmt.Sprintf("%dh%02dm", h, m)
+}
+
+/* format latitude or declination in degrees, with minutes if step is
+smaller than a degree */
+func formatLatitude(lat, step float64) string {
+	sign := "+"
+	if lat < 0 {
+		sign = "-"
+	}
+	d, m := degreesMinutes(lat)
+	if d == 0 && m == 0 {
+		sign = ""
+	}
+	if step >= 1 {
+		return fmt.Sprintf("%v%d", sign, d)
+	}
+	return fmt.Sprintf("%v%dd%02d'", sign, d, m)
+}
+
+/* format longitude in degrees, with minutes if step is smaller than
+a degree */
+func formatLongitude(lon, step float64) string {
+	d, m := degreesMinutes(geom.DEGREES.WrapX(lon))
+	d %= 360
+	if step >= 1 {
+		return fmt.Sprintf("%d", d)
+	}
+	return fmt.Sprintf("%dd%02d'", d, m)
+}
@@ -0,0 +1,176 @@
+package starmap
+
+import (
+	"encoding/json"
+	"fmt"
+	"geom"
+	"io"
+	"strings"
+	"unicode"
+)
+
+const noDataFeatureInfo = "<html><body>no data</body></html>"
+
+/* get feature info output format */
+type InfoFormat struct {
+	MimeType string
+	/* value sent in Content-Type header */
+	ContentType string
+	/* writes features to w in format */
+	write func(w io.Writer, features []*Feature) error
+}
+
+/* info formats in capabilities order, html is the default */
+var infoFormatRegistry = []*InfoFormat{
+	&InfoFormat{"text/html", "text/html; charset=utf-8", writeHTML},
+	&InfoFormat{"text/plain", "text/plain; charset=utf-8", writeText},
+	&InfoFormat{"application/json", "application/json", writeJSON},
+	&InfoFormat{"application/geo+json", "application/geo+json",
+		writeGeoJSON},
+}
+
+/* returns info format registered under mime type or nil if not found */
+func findInfoFormat(mimeType string) *InfoFormat {
+	for _, f := range infoFormatRegistry {
+		if strings.EqualFold(f.MimeType, mimeType) {
+			return f
+		}
+	}
+	return nil
+}
+
+/* returns mime types of all registered info formats */
+func infoFormats() []string {
+	rval := make([]string, len(infoFormatRegistry))
+	for i, f := range infoFormatRegistry {
+		rval[i] = f.MimeType
+	}
+	return rval
+}
+
+/* html table per feature using feature template */
+func writeHTML(w io.Writer, features []*Feature) error {
+	if len(features) < 1 {
+		_, err := io.WriteString(w, noDataFeatureInfo)
+		return err
+	}
+	return featureTemplate.Execute(w, features)
+}
+
+/* indented key: value lines per feature */
+func writeText(w io.Writer, features []*Feature) error {
+	if len(features) < 1 {
+		_, err := io.WriteString(w, "no data\n")
+		return err
+	}
+	for _, f := range features {
+		if _, err := fmt.Fprintf(w, "%v\n", f.Name); err != nil {
+			return err
+		}
+		for _, p := range f.Params {
+			if _, err := fmt.Fprintf(w, "  %v: %v\n", p.Key, p.Val); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/* json feature with typed properties */
+type jsonFeature struct {
+	Type       string                 `json:"type"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+/* features as json objects with typed properties */
+func writeJSON(w io.Writer, features []*Feature) error {
+	rval := make([]jsonFeature, len(features))
+	for i, f := range features {
+		rval[i] = jsonFeature{f.Name, properties(f)}
+	}
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"features": rval,
+	})
+}
+
+/* geojson feature */
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   interface{}            `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+/* geojson feature collection, coordinates are CRS:84 longitude and latitude
+degrees rounded like feature parameters */
+func writeGeoJSON(w io.Writer, features []*Feature) error {
+	rval := make([]geoJSONFeature, len(features))
+	for i, f := range features {
+		props := properties(f)
+		props["feature_type"] = f.Name
+		rval[i] = geoJSONFeature{"Feature", geoJSONGeometry(f.Geom), props}
+	}
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"type":     "FeatureCollection",
+		"features": rval,
+	})
+}
+
+/* convert geometry to geojson geometry object, nil if not supported */
+func geoJSONGeometry(g geom.Geometry) interface{} {
+	switch g := g.(type) {
+	case *geom.Point:
+		return map[string]interface{}{
+			"type":        "Point",
+			"coordinates": geoJSONPosition(g.X(), g.Y()),
+		}
+	case *geom.Polygon:
+		cs := g.Coords()
+		ring := make([][]float64, cs.Len())
+		for i := range ring {
+			c := cs.Get(i)
+			ring[i] = geoJSONPosition(c[0], c[1])
+		}
+		return map[string]interface{}{
+			"type":        "Polygon",
+			"coordinates": [][][]float64{ring},
+		}
+	}
+	return nil
+}
+
+/* convert right ascension and declination to a geojson position
+longitude decreases eastward from 12h at 0 degrees like CRS:84 */
+func geoJSONPosition(ra, dec float64) []float64 {
+	lon := (12 - ra) * geom.DegreesPerHour
+	return []float64{roundCoord(lon), roundCoord(dec)}
+}
+
+/* returns typed feature parameters keyed by json property name
+repeated keys are collected into arrays */
+func properties(f *Feature) map[string]interface{} {
+	rval := make(map[string]interface{}, len(f.Params))
+	repeated := make(map[string]bool)
+	for _, p := range f.Params {
+		name := propertyName(p.Key)
+		prev, exists := rval[name]
+		if !exists {
+			rval[name] = p.Value
+		} else if repeated[name] {
+			rval[name] = append(prev.([]interface{}), p.Value)
+		} else {
+			rval[name] = []interface{}{prev, p.Value}
+			repeated[name] = true
+		}
+	}
+	return rval
+}
+
+/* convert display key to json property name
+"right ascension" becomes "right_ascension", "hipparcos #" becomes
+"hipparcos" */
+func propertyName(key string) string {
+	words := strings.FieldsFunc(strings.ToLower(key), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, "_")
+}
@@ -0,0 +1,74 @@
+package starmap
+
+import (
+	"geom"
+	"image/draw"
+	"strings"
+)
+
+/* named rendering style for a layer */
+type LayerStyle struct {
+	Name  string
+	Title string
+}
+
+/* WMS layer definition */
+type Layer struct {
+	Name  string
+	Title string
+	/* bounds in stellar coordinates */
+	BBox   *geom.BoundingBox
+	Styles []LayerStyle
+	/* true if layer supports get feature info */
+	Queryable bool
+	/* true if layer is drawn over an opaque background when
+	TRANSPARENT isn't specified */
+	Opaque bool
+	/* draws layer onto image for request */
+	draw func(img draw.Image, req *Req) error
+}
+
+/* full sky in stellar coordinates */
+var fullSky = geom.NewBBox2D(0, -90, 24, 90)
+
+var defaultStyle = LayerStyle{"default", "Default"}
+
+/* layers served by WMS in capabilities order */
+var layerRegistry = []*Layer{
+	&Layer{"stars", "Stars", fullSky, []LayerStyle{defaultStyle}, true,
+		true, createStarTile},
+	&Layer{"constellations", "Constellations", fullSky,
+		[]LayerStyle{defaultStyle}, true, false, createConstTile},
+	&Layer{"asterisms", "Asterisms", fullSky, []LayerStyle{defaultStyle},
+		true, false, createAsterTile},
+	&Layer{"planets", "Sun, Moon and Planets", fullSky,
+		[]LayerStyle{defaultStyle}, true, false, createPlanetTile},
+	&Layer{"references", "Reference Lines", fullSky,
+		[]LayerStyle{defaultStyle}, true, false, createReferenceTile},
+	&Layer{"grid", "Coordinate Grid", fullSky, []LayerStyle{defaultStyle,
+		LayerStyle{"galactic", "Galactic"}, LayerStyle{"ecliptic", "Ecliptic"}},
+		false, false, createGridTile},
+}
+
+/* returns layer registered under name or nil if not found */
+func findLayer(name string) *Layer {
+	for _, l := range layerRegistry {
+		if strings.EqualFold(l.Name, name) {
+			return l
+		}
+	}
+	return nil
+}
+
+/* returns true if name is empty, default or one of the layer styles */
+func (l *Layer) HasStyle(name string) bool {
+	if name == "" || strings.EqualFold(name, defaultStyle.Name) {
+		return true
+	}
+	for _, s := range l.Styles {
+		if strings.EqualFold(s.Name, name) {
+			return true
+		}
+	}
+	return false
+}
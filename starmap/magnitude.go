@@ -0,0 +1,64 @@
+package starmap
+
+import (
+	"geom"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/* faintest naked eye magnitude for each bortle dark sky class, 1 is the
+darkest site and 9 an inner city */
+var bortleLimits = []float64{7.8, 7.3, 6.8, 6.3, 5.8, 5.3, 4.8, 4.3, 4.0}
+
+/* MAXMAG range */
+const (
+	minMagLimit = -2
+	maxMagLimit = 25
+)
+
+/* darkest gray stars are drawn with */
+const minGray = 64
+
+/*
+parse MAXMAG url parameter, or BORTLE dark sky class 1 to 9
+returns faintest magnitude drawn, +Inf if neither parameter is present.
+error if malformed, out of range or both are present
+*/
+func maxMagParam(r *http.Request) (float64, error) {
+	maxMag := strings.TrimSpace(r.FormValue("MAXMAG"))
+	bortle := strings.TrimSpace(r.FormValue("BORTLE"))
+	if maxMag != "" && bortle != "" {
+		return 0, serviceErr(InvalidParameterValue,
+			"MAXMAG and BORTLE can't both be given")
+	} else if bortle != "" {
+		class, err := strconv.Atoi(bortle)
+		if err != nil || class < 1 || class > len(bortleLimits) {
+			return 0, serviceErr(InvalidParameterValue,
+				"BORTLE must be a class from 1 to %v: %v", len(bortleLimits),
+				bortle)
+		}
+		return bortleLimits[class-1], nil
+	} else if maxMag == "" {
+		return math.Inf(1), nil
+	}
+	rval, err := strconv.ParseFloat(maxMag, 64)
+	if err != nil || !finite(rval) || rval < minMagLimit ||
+		rval > maxMagLimit {
+		return 0, serviceErr(InvalidParameterValue,
+			"MAXMAG must be a magnitude from %v to %v: %v", minMagLimit,
+			maxMagLimit, maxMag)
+	}
+	return rval, nil
+}
+
+/* takes in star and its position at the request epoch
+returns magnitude star is drawn with, dimmer through more air near the
+horizon in the observer view */
+func (r *Req) starMagnitude(s *Star, coord *geom.Point) float64 {
+	if r.Observer != nil {
+		return s.Magnitude + r.Observer.Extinction(coord)
+	}
+	return s.Magnitude
+}
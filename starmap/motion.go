@@ -0,0 +1,143 @@
+package starmap
+
+import (
+	"geom"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/* km/s for one AU per year */
+const auPerYear = 4.740470446
+
+/* supported EPOCH range in years */
+const (
+	minEpochYear = 0
+	maxEpochYear = 4000
+)
+
+/* returns true if star has proper motion or radial velocity */
+func (s *Star) Moves() bool {
+	return s.PMRA != 0 || s.PMDec != 0 ||
+		(s.Parallax != 0 && s.RadialVelocity != 0)
+}
+
+/*
+takes in julian date
+returns position of star in hours and degrees at epoch for the J2000 mean
+equinox, see CatalogEpoch
+*/
+func (s *Star) Position(epoch float64) (*geom.Point, error) {
+	coord, err := geom.UnHash(s.GeoHash, geom.STELLAR)
+	if err != nil || epoch == CatalogEpoch || !s.Moves() {
+		return coord, err
+	}
+	years := (epoch - CatalogEpoch) / 365.25
+	ra, dec := propagate(coord.X(), coord.Y(), s.PMRA, s.PMDec, s.Parallax,
+		s.RadialVelocity, years)
+	return geom.NewPoint2D(ra, dec), nil
+}
+
+/* takes in milliarc seconds returns radians */
+func masToRadians(mas float64) float64 {
+	return mas / 3600000 * math.Pi / 180
+}
+
+/*
+takes in right ascension in hours, declination in degrees, proper motion
+in right ascension times cos(dec) and declination in milliarc seconds per
+year, parallax in milliarc seconds, radial velocity in km/s and years
+returns position after moving in a straight line through space for years
+*/
+func propagate(ra, dec, pmRA, pmDec, parallax, rv,
+	years float64) (float64, float64) {
+	sinRA, cosRA := math.Sincos(ra * geom.DegreesPerHour * math.Pi / 180)
+	sinDec, cosDec := math.Sincos(dec * math.Pi / 180)
+	/* unit vectors toward the star, east and north */
+	u := [3]float64{cosDec * cosRA, cosDec * sinRA, sinDec}
+	p := [3]float64{-sinRA, cosRA, 0}
+	q := [3]float64{-sinDec * cosRA, -sinDec * sinRA, cosDec}
+	/* radial velocity as a fraction of distance per year */
+	zeta := masToRadians(rv * parallax / auPerYear)
+	east := masToRadians(pmRA) * years
+	north := masToRadians(pmDec) * years
+	var r [3]float64
+	for i := 0; i < 3; i += 1 {
+		r[i] = u[i]*(1+zeta*years) + p[i]*east + q[i]*north
+	}
+	lon := math.Atan2(r[1], r[0]) * 180 / math.Pi / geom.DegreesPerHour
+	lat := math.Atan2(r[2], math.Hypot(r[0], r[1])) * 180 / math.Pi
+	return geom.STELLAR.WrapX(lon), lat
+}
+
+/* takes in julian date
+returns furthest any star in tier moves from its catalog position in
+degrees */
+func (t *Tier) motionMargin(epoch float64) float64 {
+	if t.Motion == 0 {
+		return 0
+	}
+	years := math.Abs(epoch-CatalogEpoch) / 365.25
+	/* stars speed up across the sky as they get closer */
+	closer := 1 - t.Radial*years
+	if closer <= 0 {
+		return 180
+	}
+	return math.Min(years*t.Motion/3600/closer, 180)
+}
+
+/*
+takes in bounds in hours and degrees and julian date
+returns bounds grown to include catalog positions of stars in tier that
+are inside of bbox at epoch
+*/
+func (t *Tier) motionBounds(bbox *geom.BoundingBox,
+	epoch float64) *geom.BoundingBox {
+	margin := t.motionMargin(epoch)
+	if margin == 0 {
+		return bbox
+	}
+	lower, upper := bbox.Lower(), bbox.Upper()
+	miny := math.Max(lower.Y()-margin, -90)
+	maxy := math.Min(upper.Y()+margin, 90)
+	widest := math.Max(math.Abs(miny), math.Abs(maxy))
+	if widest >= 90 {
+		return geom.NewBBox2D(0, miny, 24, maxy)
+	}
+	dx := margin / geom.DegreesPerHour / math.Cos(widest*math.Pi/180)
+	minx, maxx := lower.X()-dx, upper.X()+dx
+	if maxx-minx >= 24 {
+		minx, maxx = 0, 24
+	}
+	return geom.NewBBox2D(minx, miny, maxx, maxy)
+}
+
+/*
+parse epoch url parameter as a julian year such as 2100.5 or J2100.5, or a
+besselian year such as B1950
+returns julian date, catalog epoch if parameter isn't present
+*/
+func epochParam(key string, r *http.Request) (float64, error) {
+	value := strings.TrimSpace(r.FormValue(key))
+	if value == "" {
+		return CatalogEpoch, nil
+	}
+	toDate := geom.JulianEpoch
+	year := value
+	switch value[0] {
+	case 'J', 'j':
+		year = value[1:]
+	case 'B', 'b':
+		toDate = geom.BesselianEpoch
+		year = value[1:]
+	}
+	rval, err := strconv.ParseFloat(year, 64)
+	if err != nil || !finite(rval) || rval < minEpochYear ||
+		rval > maxEpochYear {
+		return 0, serviceErr(InvalidParameterValue,
+			"%v must be a year from %v to %v: %v", key, minEpochYear,
+			maxEpochYear, value)
+	}
+	return toDate(rval), nil
+}
@@ -0,0 +1,218 @@
+package starmap
+
+import (
+	"fmt"
+	"geom"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"net/http"
+	"render"
+	"render/style"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* name of the crs for azimuth and altitude degrees seen by an observer,
+BBOX values are azimuth east of north and altitude */
+const horizonCRS = "STARMAP:HORIZON"
+
+/* projection for observer views unless PROJECTION is given */
+const domeProjection = "stereographic"
+
+/* layouts accepted by the TIME parameter, times without a zone are UTC */
+var timeLayouts = []string{time.RFC3339, "2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"}
+
+/* returns the current time, replaced by tests */
+var now = time.Now
+
+var horizonColor = color.RGBA{96, 192, 96, 255}
+
+/* labels for azimuths 0, 90, 180 and 270 */
+var cardinalPoints = []string{"N", "E", "S", "W"}
+
+/* pixels inside the horizon the middle of cardinal point labels are at */
+const cardinalPixels = 8
+
+/* TEMPERATURE range in degrees celsius and most PRESSURE in millibars */
+const (
+	minTemperature = -90
+	maxTemperature = 60
+	maxPressure    = 1100
+)
+
+/* place and time the sky is seen from */
+type Observer struct {
+	/* degrees, longitude is positive east */
+	Lat  float64
+	Lon  float64
+	Time time.Time
+	/* refracts and dims starlight, pressure is zero for none */
+	Atmosphere *geom.Atmosphere
+	/* azimuth and altitude frame, see geom.HorizonFrame() */
+	frame *geom.Frame
+}
+
+/* create observer at latitude and longitude in degrees at time t
+in the standard atmosphere */
+func NewObserver(lat, lon float64, t time.Time) *Observer {
+	t = t.UTC()
+	frame := geom.HorizonFrame(geom.JulianDate(t), lat, lon)
+	return &Observer{lat, lon, t, geom.StandardAtmosphere, frame}
+}
+
+/* returns local mean sidereal time in hours */
+func (o *Observer) SiderealTime() float64 {
+	return geom.LocalSiderealTime(geom.JulianDate(o.Time),
+		o.Lon) / geom.DegreesPerHour
+}
+
+/* takes in equatorial point in hours and degrees
+returns azimuth east of north and true altitude in degrees */
+func (o *Observer) Horizontal(p *geom.Point) *geom.Point {
+	return p.ToFrame(o.frame)
+}
+
+/* takes in equatorial point in hours and degrees
+returns magnitudes the atmosphere dims it by */
+func (o *Observer) Extinction(p *geom.Point) float64 {
+	alt := o.Horizontal(p).Y()
+	return o.Atmosphere.Extinction(o.Atmosphere.Apparent(alt))
+}
+
+/* see fmt.Stringer */
+func (o *Observer) String() string {
+	return fmt.Sprintf("%v,%v,%v,%v,%v", o.Lat, o.Lon,
+		o.Time.Format(time.RFC3339), o.Atmosphere.Temperature,
+		o.Atmosphere.Pressure)
+}
+
+/* returns horizon crs for observer */
+func (o *Observer) crs() *SkyCRS {
+	return &SkyCRS{Name: horizonCRS, frame: o.frame, horizon: true}
+}
+
+/*
+parse LAT, LON, TIME, TEMPERATURE and PRESSURE url parameters
+returns nil if LAT and LON aren't present, TIME defaults to now and the
+weather to the standard atmosphere.
+error if malformed or only one of LAT and LON is present
+*/
+func observerParam(r *http.Request) (*Observer, error) {
+	if r.FormValue("LAT") == "" && r.FormValue("LON") == "" {
+		return nil, nil
+	}
+	lat, err := degreesParam("LAT", 90, r)
+	if err != nil {
+		return nil, err
+	}
+	lon, err := degreesParam("LON", 180, r)
+	if err != nil {
+		return nil, err
+	}
+	t, err := timeParam("TIME", r)
+	if err != nil {
+		return nil, err
+	}
+	temperature, err := floatParam("TEMPERATURE",
+		geom.StandardAtmosphere.Temperature, minTemperature, maxTemperature, r)
+	if err != nil {
+		return nil, err
+	}
+	pressure, err := floatParam("PRESSURE", geom.StandardAtmosphere.Pressure,
+		0, maxPressure, r)
+	if err != nil {
+		return nil, err
+	}
+	rval := NewObserver(lat, lon, t)
+	rval.Atmosphere = &geom.Atmosphere{Temperature: temperature,
+		Pressure: pressure}
+	return rval, nil
+}
+
+/* parse number url parameter
+return defaultValue if parameter isn't present, error if malformed or
+not between min and max */
+func floatParam(key string, defaultValue, min, max float64,
+	r *http.Request) (float64, error) {
+	value := strings.TrimSpace(r.FormValue(key))
+	if value == "" {
+		return defaultValue, nil
+	}
+	rval, err := strconv.ParseFloat(value, 64)
+	if err != nil || !finite(rval) || rval < min || rval > max {
+		return 0, serviceErr(InvalidParameterValue,
+			"%v must be a number from %v to %v: %v", key, min, max, value)
+	}
+	return rval, nil
+}
+
+/* parse required url parameter in degrees
+returns error if missing, malformed or not between -limit and limit */
+func degreesParam(key string, limit float64, r *http.Request) (float64,
+	error) {
+	value := strings.TrimSpace(r.FormValue(key))
+	if value == "" {
+		return 0, serviceErr(MissingParameterValue,
+			"LAT and LON must be given together, missing %v", key)
+	}
+	rval, err := strconv.ParseFloat(value, 64)
+	if err != nil || !finite(rval) || math.Abs(rval) > limit {
+		return 0, serviceErr(InvalidParameterValue,
+			"%v must be degrees from %v to %v: %v", key, -limit, limit, value)
+	}
+	return rval, nil
+}
+
+/* parse UTC time url parameter such as 2024-03-20T21:30:00Z
+returns the current time to the minute if parameter isn't present */
+func timeParam(key string, r *http.Request) (time.Time, error) {
+	value := strings.TrimSpace(r.FormValue(key))
+	if value == "" {
+		return now().UTC().Truncate(time.Minute), nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, serviceErr(InvalidParameterValue,
+		"%v must be a time such as 2006-01-02T15:04:05Z: %v", key, value)
+}
+
+/* draw horizon circle and cardinal points of observer view onto img.
+the horizon is where refraction lifts stars to an apparent altitude of 0 */
+func drawHorizon(img draw.Image, req *Req) {
+	trans := req.Trans(geom.STELLAR)
+	frame := req.Observer.frame
+	atmosphere := req.Observer.Atmosphere
+	bbox := req.BBox()
+	centerx := (bbox.Lower().X() + bbox.Upper().X()) / 2
+	sample := math.Min(gridSampleDegrees, gridSamplePixels*trans.Dy)
+	s := style.NewPolyStyle(1, horizonColor)
+	alt := atmosphere.TrueAltitude(0)
+	render.RenderSeq(img, frameLine(frame, 0, alt, 360, alt, sample, centerx),
+		trans, s)
+	if charsErr != nil {
+		return
+	}
+	bounds := img.Bounds()
+	cbounds := chars.Bounds()
+	height := cbounds.Max.Y - cbounds.Min.Y
+	for i, label := range cardinalPoints {
+		/* above the horizon so labels are drawn inside the dome */
+		alt := atmosphere.TrueAltitude(cardinalPixels * trans.Dy)
+		p := geom.NewPoint2D(float64(i)*90, alt)
+		p = p.FromFrame(frame)
+		pix, ok := trans.TransformVisible(p.X(), p.Y())
+		if !ok || !pix.In(bounds) {
+			continue
+		}
+		/* center label on the point */
+		pix = &image.Point{pix.X - len(label)*charWidth/2, pix.Y - height/2}
+		drawLabel(img, pix, label, horizonColor)
+	}
+}
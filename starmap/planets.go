@@ -0,0 +1,150 @@
+package starmap
+
+import (
+	"geom"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"render"
+	"render/style"
+	"sort"
+	"strings"
+)
+
+/* symbol drawn for a body */
+type bodyLook struct {
+	shape style.Shape
+	color color.Color
+}
+
+/* looks indexed by geom.Body, the sun and moon are disks and the planets
+squares so they can't be mistaken for stars */
+var bodyLooks = []bodyLook{
+	{style.CIRCLE, color.RGBA{255, 224, 64, 255}},
+	{style.CIRCLE, color.RGBA{224, 224, 208, 255}},
+	{style.SQUARE, color.RGBA{176, 160, 144, 255}},
+	{style.SQUARE, color.RGBA{255, 255, 200, 255}},
+	{style.SQUARE, color.RGBA{255, 96, 64, 255}},
+	{style.SQUARE, color.RGBA{240, 200, 150, 255}},
+	{style.SQUARE, color.RGBA{224, 192, 112, 255}},
+	{style.SQUARE, color.RGBA{160, 224, 224, 255}},
+	{style.SQUARE, color.RGBA{96, 128, 255, 255}},
+}
+
+/* symbol size in pixels for magnitude 0 and growth per magnitude brighter */
+const (
+	bodyPixels       = 2
+	bodyPixelsPerMag = 0.5
+	minBodyPixels    = 1.5
+	maxBodyPixels    = 6
+)
+
+/* pixels between a symbol and its label */
+const bodyLabelGap = 2
+
+/* returns size in pixels of body's symbol, brighter bodies are larger and
+disks are never drawn smaller than they appear */
+func bodySize(e *geom.Ephemeris, trans *geom.PointTransform) float64 {
+	size := bodyPixels - bodyPixelsPerMag*e.Magnitude
+	size = math.Max(math.Min(size, maxBodyPixels), minBodyPixels)
+	return math.Max(size, e.Radius/trans.Dy)
+}
+
+/* draw the sun, moon and planets at the request time onto img */
+func createPlanetTile(img draw.Image, req *Req) error {
+	trans := req.Trans(geom.STELLAR)
+	bbox := req.BBox()
+	centerx := (bbox.Lower().X() + bbox.Upper().X()) / 2
+	for _, e := range geom.SolarSystem(geom.JulianDate(req.Time)) {
+		/* bodies across 0h from the center of the tile */
+		x := geom.STELLAR.NearestX(e.Position.X(), centerx)
+		pix, ok := trans.TransformVisible(x, e.Position.Y())
+		if !ok {
+			continue
+		}
+		look := bodyLooks[e.Body]
+		size := bodySize(e, trans)
+		render.Render(img, pix, style.NewPointStyle(size, look.color,
+			look.shape))
+		if charsErr == nil && pix.In(img.Bounds()) {
+			cbounds := chars.Bounds()
+			height := cbounds.Max.Y - cbounds.Min.Y
+			label := &image.Point{pix.X + int(size) + bodyLabelGap,
+				pix.Y - height/2}
+			drawLabel(img, label, e.Body.String(), look.color)
+		}
+	}
+	return nil
+}
+
+/* body and its distance from a query point */
+type bodyMatch struct {
+	body *geom.Ephemeris
+	dist float64
+}
+
+/* sorts matches nearest first */
+type bodiesByDistance []bodyMatch
+
+/* sort interface */
+func (m bodiesByDistance) Len() int {
+	return len(m)
+}
+
+/* sort interface */
+func (m bodiesByDistance) Swap(i, j int) {
+	m[i], m[j] = m[j], m[i]
+}
+
+/* sort interface */
+func (m bodiesByDistance) Less(i, j int) bool {
+	return m[i].dist < m[j].dist
+}
+
+/* get planets layer feature info for up to count bodies whose symbols are
+within tolerance pixels of point, nearest first */
+func planetFeatures(req *Req, point *geom.Point, trans *geom.PointTransform,
+	tolerance, count int) []*Feature {
+	matches := make(bodiesByDistance, 0, 2)
+	for _, e := range geom.SolarSystem(geom.JulianDate(req.Time)) {
+		reach := pixelDegrees(trans, float64(tolerance)+bodySize(e, trans))
+		if dist := point.AngularDistance(e.Position); dist <= reach {
+			matches = append(matches, bodyMatch{e, dist})
+		}
+	}
+	sort.Sort(matches)
+	if len(matches) > count {
+		matches = matches[:count]
+	}
+	rval := make([]*Feature, len(matches))
+	for i, m := range matches {
+		name := "planet"
+		if m.body.Body == geom.Sun || m.body.Body == geom.Moon {
+			name = strings.ToLower(m.body.Body.String())
+		}
+		rval[i] = &Feature{name, bodyAsParams(m.body), m.body.Position}
+	}
+	return rval
+}
+
+/* convert an ephemeris into feature parameters */
+func bodyAsParams(e *geom.Ephemeris) []Param {
+	rval := make([]Param, 0, 8)
+	rval = addParam(rval, "name", e.Body.String())
+	rval = addParam(rval, "magnitude", math.Floor(e.Magnitude*100+0.5)/100)
+	rval = addCoordParam(rval, "right ascension", e.Position.X())
+	rval = addCoordParam(rval, "declination", e.Position.Y())
+	if e.Body == geom.Moon {
+		km := e.Distance * geom.KmPerAU
+		rval = addParam(rval, "distance km", math.Floor(km+0.5))
+	} else {
+		rval = addCoordParam(rval, "distance au", e.Distance)
+	}
+	if e.Body != geom.Sun {
+		rval = addCoordParam(rval, "elongation", e.Elongation)
+		rval = addCoordParam(rval, "phase", e.Phase)
+		rval = addCoordParam(rval, "phase angle", e.PhaseAngle)
+	}
+	return rval
+}
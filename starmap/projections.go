@@ -0,0 +1,46 @@
+package starmap
+
+import (
+	"geom"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+/* name of the default linear scale of right ascension and declination */
+const plateCarree = "plate-carree"
+
+/* projections that can be requested with the PROJECTION parameter,
+projections are centered on the middle of the request bounds */
+var projectionRegistry = map[string]geom.ProjectionFunc{
+	"stereographic":         geom.NewStereographic,
+	"orthographic":          geom.NewOrthographic,
+	"gnomonic":              geom.NewGnomonic,
+	"azimuthal-equidistant": geom.NewAzimuthalEquidistant,
+	"mollweide":             geom.NewMollweide,
+	"hammer-aitoff":         geom.NewHammerAitoff,
+}
+
+/* returns the names of all supported projections, sorted */
+func projections() []string {
+	rval := []string{plateCarree}
+	for name := range projectionRegistry {
+		rval = append(rval, name)
+	}
+	sort.Strings(rval[1:])
+	return rval
+}
+
+/* parse PROJECTION parameter
+returns empty string for the default linear scale */
+func projectionParam(key string, r *http.Request) (string, error) {
+	name := strings.ToLower(strings.TrimSpace(r.FormValue(key)))
+	if name == "" || name == plateCarree {
+		return "", nil
+	}
+	if _, ok := projectionRegistry[name]; !ok {
+		return "", serviceErr(InvalidParameterValue,
+			"Unsupported %v: %v", key, r.FormValue(key))
+	}
+	return name, nil
+}
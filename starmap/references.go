@@ -0,0 +1,146 @@
+package starmap
+
+import (
+	"geom"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"render"
+	"render/style"
+	"time"
+)
+
+/* half width of the zodiac band in degrees of ecliptic latitude */
+const zodiacHalfWidth = 8
+
+/* half length of ecliptic month ticks in degrees */
+const monthTickDegrees = 1.5
+
+/* year the sun's position on the first of each month is taken from */
+const monthTickYear = 2000
+
+/* reference circle drawn by the references layer */
+type Reference struct {
+	Name  string
+	frame *geom.Frame
+	/* latitudes in frame of the lines drawn */
+	lats []float64
+	/* true if the area between the lines is the reference */
+	band bool
+	/* true to draw ticks where the sun is on the first of each month */
+	months bool
+	color  color.Color
+}
+
+/* references in drawing order */
+var references = []*Reference{
+	&Reference{"zodiac", geom.EclipticFrame,
+		[]float64{-zodiacHalfWidth, zodiacHalfWidth}, true, false,
+		color.RGBA{160, 140, 64, 255}},
+	&Reference{"celestial equator", geom.EquatorialFrame, []float64{0},
+		false, false, color.RGBA{96, 160, 255, 255}},
+	&Reference{"galactic equator", geom.GalacticFrame, []float64{0},
+		false, false, color.RGBA{208, 128, 255, 255}},
+	&Reference{"ecliptic", geom.EclipticFrame, []float64{0}, false, true,
+		color.RGBA{255, 224, 96, 255}},
+}
+
+/* zodiac signs in order of ecliptic longitude, 30 degrees each */
+var zodiacSigns = []string{"Aries", "Taurus", "Gemini", "Cancer", "Leo",
+	"Virgo", "Libra", "Scorpio", "Sagittarius", "Capricorn", "Aquarius",
+	"Pisces"}
+
+/* draw reference lines onto img */
+func createReferenceTile(img draw.Image, req *Req) error {
+	trans := req.Trans(geom.STELLAR)
+	bbox := req.BBox()
+	centerx := (bbox.Lower().X() + bbox.Upper().X()) / 2
+	sample := math.Min(gridSampleDegrees, gridSamplePixels*trans.Dy)
+	for _, ref := range references {
+		s := style.NewPolyStyle(1, ref.color)
+		for _, lat := range ref.lats {
+			cs := frameLine(ref.frame, 0, lat, 360, lat, sample, centerx)
+			for _, shift := range seamShifts(trans) {
+				render.RenderSeq(img, shiftSeq(cs, shift), trans, s)
+			}
+		}
+		if ref.months {
+			drawMonthTicks(img, ref, trans, s, centerx)
+		}
+	}
+	return nil
+}
+
+/* draw ticks across reference where the sun is on the first of each month
+labels are drawn at the north end of ticks */
+func drawMonthTicks(img draw.Image, ref *Reference, trans *geom.PointTransform,
+	s *style.PolygonStyle, centerx float64) {
+	bounds := img.Bounds()
+	for m := time.January; m <= time.December; m += 1 {
+		first := time.Date(monthTickYear, m, 1, 0, 0, 0, 0, time.UTC)
+		lon := geom.SunLongitude(geom.JulianDate(first))
+		cs := frameLine(ref.frame, lon, -monthTickDegrees, lon,
+			monthTickDegrees, monthTickDegrees, centerx)
+		for _, shift := range seamShifts(trans) {
+			tick := shiftSeq(cs, shift)
+			render.RenderSeq(img, tick, trans, s)
+			if charsErr != nil {
+				continue
+			}
+			end := tick.Get(tick.Len() - 1)
+			pix, ok := trans.TransformVisible(end[0], end[1])
+			if ok && pix.In(bounds) {
+				render.RenderString(img, chars, charWidth,
+					&image.Point{pix.X, pix.Y}, m.String()[:3], ref.color)
+			}
+		}
+	}
+}
+
+/*
+takes in equatorial point and tolerance in degrees
+returns references with lines within tolerance of point, or bands that
+contain point
+*/
+func findReferences(point *geom.Point, tolerance float64) []*Reference {
+	rval := make([]*Reference, 0, 2)
+	for _, ref := range references {
+		lat := point.ToFrame(ref.frame).Y()
+		if ref.band {
+			if lat >= ref.lats[0]-tolerance &&
+				lat <= ref.lats[len(ref.lats)-1]+tolerance {
+				rval = append(rval, ref)
+			}
+			continue
+		}
+		for _, l := range ref.lats {
+			if math.Abs(lat-l) <= tolerance {
+				rval = append(rval, ref)
+				break
+			}
+		}
+	}
+	return rval
+}
+
+/* get references layer feature info for point within tolerance degrees */
+func referenceFeatures(point *geom.Point, tolerance float64) []*Feature {
+	refs := findReferences(point, tolerance)
+	rval := make([]*Feature, len(refs))
+	for i, ref := range refs {
+		params := make([]Param, 0, 3)
+		params = addParam(params, "name", ref.Name)
+		p := point.ToFrame(ref.frame)
+		if ref.frame != geom.EquatorialFrame {
+			params = addCoordParam(params, "longitude", p.X())
+			params = addCoordParam(params, "latitude", p.Y())
+		}
+		if ref.band && ref.frame == geom.EclipticFrame {
+			sign := zodiacSigns[int(p.X()/30)%len(zodiacSigns)]
+			params = addParam(params, "sign", sign)
+		}
+		rval[i] = &Feature{"reference", params, nil}
+	}
+	return rval
+}
@@ -0,0 +1,263 @@
+package starmap
+
+import (
+	"geom"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* sidereal degrees per day of universal time */
+const siderealRate = 360.98564736629
+
+/* rise, upper transit and set of a sky position for an observer */
+type RiseSet struct {
+	/* zero if the position is circumpolar or never rises */
+	Rise time.Time
+	Set  time.Time
+	/* upper transit, crossing the meridian at the highest altitude */
+	Transit time.Time
+	/* degrees above the horizon at upper transit */
+	MaxAltitude float64
+	/* true if the position never sets */
+	Circumpolar bool
+	/* true if the position is always below the horizon */
+	NeverRises bool
+}
+
+/*
+takes in J2000 equatorial point in hours and degrees
+returns the next upper transit at or after the observer's time with the
+rise before it and the set after it, so the rise may be before the
+observer's time. the point is precessed to the date, motion during the day
+is ignored. it rises and sets where refraction lifts it to the horizon
+*/
+func (o *Observer) RiseSet(p *geom.Point) *RiseSet {
+	jd := geom.JulianDate(o.Time)
+	p = p.Precess(CatalogEpoch, jd)
+	ra, dec := p.X()*geom.DegreesPerHour, p.Y()
+	lat := o.Lat * math.Pi / 180
+	/* hour angle at the observer's time */
+	hourAngle := geom.LocalSiderealTime(jd, o.Lon) - ra
+	untilTransit := math.Mod(-hourAngle, 360)
+	if untilTransit < 0 {
+		untilTransit += 360
+	}
+	transit := o.Time.Add(siderealDuration(untilTransit))
+	rval := &RiseSet{MaxAltitude: 90 - math.Abs(o.Lat-dec)}
+	rval.Transit = transit.Round(time.Second)
+	riseAltitude := o.Atmosphere.TrueAltitude(0)
+	cosH := (math.Sin(riseAltitude*math.Pi/180) -
+		math.Sin(lat)*math.Sin(dec*math.Pi/180)) /
+		(math.Cos(lat) * math.Cos(dec*math.Pi/180))
+	if cosH < -1 {
+		rval.Circumpolar = true
+	} else if cosH > 1 {
+		rval.NeverRises = true
+	} else {
+		/* hour angle at the horizon */
+		half := siderealDuration(math.Acos(cosH) * 180 / math.Pi)
+		rval.Rise = transit.Add(-half).Round(time.Second)
+		rval.Set = transit.Add(half).Round(time.Second)
+	}
+	return rval
+}
+
+/* returns time for the sky to turn degrees */
+func siderealDuration(degrees float64) time.Duration {
+	return time.Duration(degrees / siderealRate * 24 * float64(time.Hour))
+}
+
+/*
+returns center of the constellation in hours and degrees, the area
+weighted centroid of its boundary polygons. polygons split at 0h are
+joined back together
+*/
+func (c *Constellation) Center() *geom.Point {
+	var x, y, area float64
+	var ref *geom.Point
+	for _, pi := range c.PolyInfos {
+		center, a := pi.Geom.Centroid()
+		a = math.Abs(a)
+		if ref == nil {
+			ref = center
+		}
+		x += geom.STELLAR.NearestX(center.X(), ref.X()) * a
+		y += center.Y() * a
+		area += a
+	}
+	if area == 0 {
+		return ref
+	}
+	return geom.NewPoint2D(geom.STELLAR.WrapX(x/area), y/area)
+}
+
+/* returns constellation named name ignoring case, nil if not found */
+func findConstellation(name string) *Constellation {
+	for _, c := range constelData {
+		if strings.EqualFold(c.Name, name) {
+			return c
+		}
+	}
+	return nil
+}
+
+/* returns star with hipparcos number hip from any catalog tier,
+nil if not found */
+func (c *Catalog) FindHip(ctx Logger, hip int32) *Star {
+	for _, tier := range c.Tiers(ctx, len(c.levels)) {
+		for _, s := range tier.Stars {
+			if s.HipNum == hip {
+				return s
+			}
+		}
+	}
+	return nil
+}
+
+/* append rise, transit and set parameters to dest */
+func riseSetParams(dest []Param, rs *RiseSet) []Param {
+	if !rs.Rise.IsZero() {
+		dest = addParam(dest, "rise", rs.Rise.Format(time.RFC3339))
+	}
+	dest = addParam(dest, "transit", rs.Transit.Format(time.RFC3339))
+	if !rs.Set.IsZero() {
+		dest = addParam(dest, "set", rs.Set.Format(time.RFC3339))
+	}
+	dest = addCoordParam(dest, "max altitude", rs.MaxAltitude)
+	if rs.Circumpolar {
+		dest = addParam(dest, "circumpolar", true)
+	} else if rs.NeverRises {
+		dest = addParam(dest, "never rises", true)
+	}
+	return dest
+}
+
+/* get rise, transit and set feature for the star with hipparcos number
+value */
+func starRiseSet(r *http.Request, obs *Observer, value string) (*Feature,
+	error) {
+	hip, err := strconv.ParseInt(strings.TrimSpace(value), 10, 32)
+	if err != nil {
+		return nil, serviceErr(InvalidParameterValue,
+			"HIP must be an integer: %v", value)
+	}
+	star := catalog.FindHip(requestLogger(r), int32(hip))
+	if star == nil {
+		return nil, serviceErr(InvalidParameterValue, "Unknown HIP: %v", hip)
+	}
+	/* star moved to the observer's date but for the J2000 equinox */
+	coord, err := star.Position(geom.JulianDate(obs.Time))
+	if err != nil {
+		return nil, err
+	}
+	params := make([]Param, 0, 8)
+	params = addParam(params, "hipparcos #", int(star.HipNum))
+	if star.Name != "" {
+		params = addParam(params, "name", star.Name)
+	}
+	params = riseSetParams(params, obs.RiseSet(coord))
+	return &Feature{"star", params, coord}, nil
+}
+
+/* get rise, transit and set feature for the center of constellation
+named value */
+func constelRiseSet(obs *Observer, value string) (*Feature, error) {
+	if constelErr != nil {
+		return nil, constelErr
+	}
+	c := findConstellation(strings.TrimSpace(value))
+	if c == nil {
+		return nil, serviceErr(InvalidParameterValue,
+			"Unknown CONSTELLATION: %v", value)
+	}
+	center := c.Center()
+	params := make([]Param, 0, 8)
+	params = addParam(params, "name", c.Name)
+	params = addCoordParam(params, "right ascension", center.X())
+	params = addCoordParam(params, "declination", center.Y())
+	params = riseSetParams(params, obs.RiseSet(center))
+	return &Feature{"constellation", params, center}, nil
+}
+
+/*
+parse observer for rise and set requests. takes LAT, LON and TIME like
+GetMap, or DATE instead of TIME for midnight UTC of that day
+*/
+func riseSetObserver(r *http.Request) (*Observer, error) {
+	obs, err := observerParam(r)
+	if err != nil {
+		return nil, err
+	} else if obs == nil {
+		return nil, serviceErr(MissingParameterValue,
+			"LAT and LON are required")
+	}
+	date := strings.TrimSpace(r.FormValue("DATE"))
+	if date == "" {
+		return obs, nil
+	} else if strings.TrimSpace(r.FormValue("TIME")) != "" {
+		return nil, serviceErr(InvalidParameterValue,
+			"DATE and TIME can't both be given")
+	}
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return nil, serviceErr(InvalidParameterValue,
+			"DATE must be a date such as 2006-01-02: %v", date)
+	}
+	rval := NewObserver(obs.Lat, obs.Lon, t)
+	rval.Atmosphere = obs.Atmosphere
+	return rval, nil
+}
+
+/*
+handler for rise, transit and set requests. takes an observer, see
+riseSetObserver, and HIP or CONSTELLATION or both. events are around the
+next transit after the observer's time. output is in INFO_FORMAT like
+GetFeatureInfo
+*/
+func getriseset(w http.ResponseWriter, r *http.Request) {
+	if templateErr != nil {
+		doErr(w, r, templateErr)
+		return
+	}
+	infoFormat := findInfoFormat(strParam("INFO_FORMAT", "text/html", r))
+	if infoFormat == nil {
+		doErr(w, r, serviceErr(InvalidFormat, "Unsupported INFO_FORMAT: %v",
+			r.FormValue("INFO_FORMAT")))
+		return
+	}
+	obs, err := riseSetObserver(r)
+	if err != nil {
+		doErr(w, r, err)
+		return
+	}
+	hip, constel := r.FormValue("HIP"), r.FormValue("CONSTELLATION")
+	if hip == "" && constel == "" {
+		doErr(w, r, serviceErr(MissingParameterValue,
+			"HIP or CONSTELLATION is required"))
+		return
+	}
+	features := make([]*Feature, 0, 2)
+	if hip != "" {
+		f, err := starRiseSet(r, obs, hip)
+		if err != nil {
+			doErr(w, r, err)
+			return
+		}
+		features = append(features, f)
+	}
+	if constel != "" {
+		f, err := constelRiseSet(obs, constel)
+		if err != nil {
+			doErr(w, r, err)
+			return
+		}
+		features = append(features, f)
+	}
+	w.Header().Set("Content-Type", infoFormat.ContentType)
+	if err := infoFormat.write(w, features); err != nil {
+		doErr(w, r, err)
+	}
+}
@@ -1,28 +1,118 @@
 package starmap
 
 import (
-	"appengine"
 	"bufio"
 	"geom"
 	"math"
 	"os"
+	"path"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 )
 
-/* cache entry for zoom level */
+/* number of geohash characters used when covering query bounds */
+const coverPrecision = 3
+
+/* star positions are for the J2000 mean equinox */
+const CatalogEpoch = geom.J2000
+
+/* star catalog tier files, brightest first */
+var tierFiles = []string{"bright.tsv", "tier2.tsv", "tier3.tsv", "tier4.tsv"}
+
+/* brightest magnitude in the tier file after each tier file, every star
+of a tier is fainter than every star of the tiers before it */
+var tierLimits = []float64{6, 6.8, 7.3}
+
+/* catalog tier for zoom level, loaded once and then read only */
 type Level struct {
+	/* full path to tier file */
 	Datafile string
-	Data     Stardata
+	once     sync.Once
+	tier     *Tier
+	err      error
+}
+
+/* returns tier for level, loading it on first call
+safe for concurrent use */
+func (l *Level) Tier(ctx Logger) (*Tier, error) {
+	l.once.Do(func() {
+		ctx.Infof("Loading %v", l.Datafile)
+		var data Stardata
+		data, l.err = LoadData(l.Datafile)
+		if l.err != nil {
+			ctx.Errorf("Unable to load %v: %v", l.Datafile, l.err)
+			return
+		}
+		l.tier = NewTier(data)
+	})
+	return l.tier, l.err
+}
+
+/* stars of a catalog tier and how fast the fastest of them move */
+type Tier struct {
+	Stars Stardata
+	/* fastest proper motion in arc seconds per year, zero if no star
+	moves */
+	Motion float64
+	/* fastest change in distance as a fraction of the distance per year */
+	Radial float64
+}
+
+/* create tier for stars, recording their fastest motion */
+func NewTier(stars Stardata) *Tier {
+	rval := &Tier{Stars: stars}
+	for _, s := range stars {
+		motion := math.Hypot(s.PMRA, s.PMDec) / 1000
+		radial := math.Abs(masToRadians(s.RadialVelocity * s.Parallax /
+			auPerYear))
+		rval.Motion = math.Max(rval.Motion, motion)
+		rval.Radial = math.Max(rval.Radial, radial)
+	}
+	return rval
+}
+
+/* star catalog split into brightness tiers */
+type Catalog struct {
+	levels []*Level
+}
+
+/* create a catalog for tier files in data directory
+tiers are loaded on first use, see LoadAll() */
+func NewCatalog(dataDir string) *Catalog {
+	levels := make([]*Level, len(tierFiles))
+	for i, name := range tierFiles {
+		levels[i] = &Level{Datafile: path.Join(dataDir, name)}
+	}
+	return &Catalog{levels}
 }
 
-/* zoom level data cache */
-var cache = []Level{
-	Level{"data/bright.tsv", nil},
-	Level{"data/tier2.tsv", nil},
-	Level{"data/tier3.tsv", nil},
-	Level{"data/tier4.tsv", nil},
+/* load all tiers, returns the first error encountered */
+func (c *Catalog) LoadAll(ctx Logger) error {
+	var rval error
+	for _, level := range c.levels {
+		if _, err := level.Tier(ctx); err != nil && rval == nil {
+			rval = err
+		}
+	}
+	return rval
+}
+
+/* returns the first num tiers, dimmest first so dim stars
+get drawn first. Tiers that fail to load are skipped */
+func (c *Catalog) Tiers(ctx Logger, num int) []*Tier {
+	if num > len(c.levels) {
+		num = len(c.levels)
+	}
+	rval := make([]*Tier, 0, num)
+	for i := num - 1; i >= 0; i -= 1 {
+		tier, err := c.levels[i].Tier(ctx)
+		if err == nil {
+			rval = append(rval, tier)
+		}
+	}
+	return rval
 }
 
 type Star struct {
@@ -34,6 +124,14 @@ type Star struct {
 	Magnitude float64
 	/* geohash of right declination and ascension */
 	GeoHash string
+	/* proper motion in milliarc seconds per year, right ascension is
+	multiplied by cos(dec). zero if not in the catalog */
+	PMRA  float64
+	PMDec float64
+	/* milliarc seconds, zero if not in the catalog */
+	Parallax float64
+	/* km/s, positive moves away. zero if not in the catalog */
+	RadialVelocity float64
 }
 
 type Stardata []*Star
@@ -61,36 +159,133 @@ func (sd Stardata) FindIndex(geohash string) int {
 	})
 }
 
-/* takes in a point, returns closest star or nil if not found */
-func FindClosest(sr *StarReq, p *geom.Point) *Star {
-	lower := geom.NewPoint2D(p.X()+0.5, p.Y()-1)
-	upper := geom.NewPoint2D(p.X()-0.5, p.Y()+1)
-	lowerHash, upperHash := geom.BBoxHash(lower, upper, geom.STELLAR)
+/* takes in catalog tiers and a point
+returns closest star within a degree or nil if not found */
+func FindClosest(tiers []*Tier, p *geom.Point) *Star {
+	stars := FindWithin(tiers, p, 1, 1)
+	if len(stars) > 0 {
+		return stars[0]
+	}
+	return nil
+}
+
+/* star and its distance from a query point */
+type starMatch struct {
+	star *Star
+	dist float64
+}
 
-	var rval *Star = nil
-	var minDist float64 = math.MaxFloat64
+/* sorts matches nearest first */
+type byDistance []starMatch
 
-	for sd := range sr.out {
-		stars := sd.Range(lowerHash, upperHash)
-		for _, s := range stars {
-			coord, err := geom.UnHash(s.GeoHash, geom.STELLAR)
-			if err != nil {
-				continue
-			}
-			x := math.Abs(p.X() - coord.X())
-			xx := x * x
-			y := math.Abs(p.Y() - coord.Y())
-			yy := y * y
-			zz := xx + yy
-			if minDist > zz {
-				minDist = zz
-				rval = s
+/* sort interface */
+func (m byDistance) Len() int {
+	return len(m)
+}
+
+/* sort interface */
+func (m byDistance) Swap(i, j int) {
+	m[i], m[j] = m[j], m[i]
+}
+
+/* sort interface */
+func (m byDistance) Less(i, j int) bool {
+	return m[i].dist < m[j].dist
+}
+
+/*
+takes in catalog tiers, a point, the transform used to draw the point,
+a tolerance in pixels and julian date of star positions.
+returns up to count stars within tolerance of point, nearest first
+*/
+func FindNearest(tiers []*Tier, p *geom.Point, trans *geom.PointTransform,
+	tolerance float64, count int, epoch float64) []*Star {
+	return FindWithinEpoch(tiers, p, pixelDegrees(trans, tolerance), count,
+		epoch)
+}
+
+/*
+takes in catalog tiers, a point and a radius in degrees
+returns up to count stars within radius of point, nearest first
+*/
+func FindWithin(tiers []*Tier, p *geom.Point, radius float64,
+	count int) []*Star {
+	return FindWithinEpoch(tiers, p, radius, count, CatalogEpoch)
+}
+
+/*
+takes in catalog tiers, a point, a radius in degrees and julian date
+returns up to count stars within radius of point at epoch, nearest first
+*/
+func FindWithinEpoch(tiers []*Tier, p *geom.Point, radius float64,
+	count int, epoch float64) []*Star {
+	return findWithin(tiers, p, radius, count, epoch, nil)
+}
+
+/*
+takes in catalog tiers, a point, a radius in degrees, julian date and a
+function that returns true for stars to include, nil for every star
+returns up to count included stars within radius of point at epoch,
+nearest first
+*/
+func findWithin(tiers []*Tier, p *geom.Point, radius float64, count int,
+	epoch float64, keep func(*Star, *geom.Point) bool) []*Star {
+	matches := make(byDistance, 0, count)
+	for _, tier := range tiers {
+		/* stars may have moved into radius from their catalog position */
+		search := radius + tier.motionMargin(epoch)
+		lowery := math.Max(p.Y()-search, -90)
+		uppery := math.Min(p.Y()+search, 90)
+		halfWidth := geom.RAHalfWidth(p, search)
+		for _, xs := range raRanges(p.X(), halfWidth) {
+			lower := geom.NewPoint2D(xs[1], lowery)
+			upper := geom.NewPoint2D(xs[0], uppery)
+			ranges := geom.BBoxCover(lower, upper, coverPrecision,
+				geom.STELLAR)
+			for _, found := range tier.Stars.Ranges(ranges) {
+				for _, s := range found {
+					coord, err := s.Position(epoch)
+					if err != nil {
+						continue
+					}
+					dist := p.AngularDistance(coord)
+					if dist <= radius && (keep == nil || keep(s, coord)) {
+						matches = append(matches, starMatch{s, dist})
+					}
+				}
 			}
 		}
 	}
+	sort.Sort(matches)
+	if len(matches) > count {
+		matches = matches[:count]
+	}
+	rval := make([]*Star, len(matches))
+	for i, m := range matches {
+		rval[i] = m.star
+	}
 	return rval
 }
 
+/*
+takes in right ascension and half width in hours
+returns non overlapping [min, max] right ascension ranges covering
+ra - halfWidth to ra + halfWidth, split where they cross 0h/24h
+*/
+func raRanges(ra, halfWidth float64) [][2]float64 {
+	if halfWidth >= 12 {
+		return [][2]float64{{0, 24}}
+	}
+	min := ra - halfWidth
+	max := ra + halfWidth
+	if min < 0 {
+		return [][2]float64{{0, max}, {min + 24, 24}}
+	} else if max > 24 {
+		return [][2]float64{{min, 24}, {0, max - 24}}
+	}
+	return [][2]float64{{min, max}}
+}
+
 /* takes in two strings returns the length of the common prefix */
 func sharedLen(a, b string) int {
 	length := int(math.Min(float64(len(a)), float64(len(b))))
@@ -121,16 +316,99 @@ func (sd Stardata) Range(start, end string) Stardata {
 	return sd[startIndex:endIndex]
 }
 
-/* wraps a request with a return channel */
-type StarReq struct {
-	req *Req
-	out chan Stardata
+/*
+takes in bounds, the transform used to draw them and a margin in pixels
+returns stars inside of bounds grown by margin on each side, so stars drawn
+larger than a point near the edge of bounds are included. bounds may wrap
+across 0h, see geom.SplitBBox2D()
+*/
+func (sd Stardata) Query(bbox *geom.BoundingBox, trans *geom.PointTransform,
+	margin int) Stardata {
+	dx := float64(margin) * trans.Dx
+	dy := float64(margin) * trans.Dy
+	lower, upper := bbox.Lower(), bbox.Upper()
+	return sd.query(lower.X()-dx, lower.Y()-dy, upper.X()+dx, upper.Y()+dy)
+}
+
+/* returns stars inside of bounds in hours and degrees,
+x may extend past 0h or 24h */
+func (sd Stardata) query(minx, miny, maxx, maxy float64) Stardata {
+	if maxx-minx >= 24 {
+		/* don't overlap with itself when wrapped */
+		minx, maxx = 0, 24
+	}
+	miny = math.Max(miny, -90)
+	maxy = math.Min(maxy, 90)
+	rval := make(Stardata, 0)
+	for _, part := range geom.SplitBBox2D(minx, miny, maxx, maxy,
+		geom.STELLAR) {
+		ranges := geom.BBoxCover(part.Lower(), part.Upper(), coverPrecision,
+			geom.STELLAR)
+		for _, found := range sd.Ranges(ranges) {
+			for _, s := range found {
+				coord, err := geom.UnHash(s.GeoHash, geom.STELLAR)
+				if err == nil && part.Covers(coord) {
+					rval = append(rval, s)
+				}
+			}
+		}
+	}
+	return rval
+}
+
+/*
+takes in frame and bounding box of longitude and latitude in frame degrees,
+longitude may extend past 0 or 360 degrees
+returns stars inside of the box, see geom.DEGREES
+*/
+func (sd Stardata) QueryFrame(frame *geom.Frame,
+	bbox *geom.BoundingBox) Stardata {
+	eq := geom.EquatorialBounds(frame, bbox)
+	lower, upper := bbox.Lower(), bbox.Upper()
+	center := (lower.X() + upper.X()) / 2
+	rval := make(Stardata, 0)
+	for _, s := range sd.query(eq.Lower().X(), eq.Lower().Y(),
+		eq.Upper().X(), eq.Upper().Y()) {
+		coord, err := geom.UnHash(s.GeoHash, geom.STELLAR)
+		if err != nil {
+			continue
+		}
+		p := coord.ToFrame(frame)
+		lon := geom.DEGREES.NearestX(p.X(), center)
+		if lon >= lower.X() && lon <= upper.X() &&
+			p.Y() >= lower.Y() && p.Y() <= upper.Y() {
+			rval = append(rval, s)
+		}
+	}
+	return rval
+}
+
+/* takes in sorted, non overlapping geohash search ranges
+returns slices of data that are inside each range, see geom.BBoxCover() */
+func (sd Stardata) Ranges(ranges []geom.HashRange) []Stardata {
+	rval := make([]Stardata, 0, len(ranges))
+	for _, r := range ranges {
+		if found := sd.Range(r.Min, r.Max); len(found) > 0 {
+			rval = append(rval, found)
+		}
+	}
+	return rval
 }
 
 /* takes in request and returns the number of levels
+that should be drawn, tiers with only stars fainter than the request
+limit are left out */
+func levels(req *Req) int {
+	rval := scaleLevels(req.Scale())
+	for rval > 1 && tierLimits[rval-2] > req.MaxMag {
+		rval -= 1
+	}
+	return rval
+}
+
+/* takes in scale in hours per pixel and returns the number of levels
 that should be drawn */
-func levels(sr *StarReq) int {
-	scale := sr.req.Scale()
+func scaleLevels(scale float64) int {
 	if scale <= 0.00146484375 {
 		return 4
 	} else if scale <= 0.0029296875 {
@@ -142,32 +420,11 @@ func levels(sr *StarReq) int {
 	}
 }
 
-/* logic responsible for handling star data cache
-runs as goroutine the takes in a request channel */
-func starReqHandler(c chan *StarReq) {
-	for req := range c {
-		levelNum := levels(req)
-		/* walk reverse so dim gets drawn first */
-		for i := levelNum - 1; i >= 0; i -= 1 {
-			level := &cache[i]
-			if level.Data == nil {
-				ctx := appengine.NewContext(req.req.httpr)
-				ctx.Infof("Loading %v", level.Datafile)
-				data, err := LoadData(level.Datafile)
-				level.Data = data
-				if err != nil {
-					ctx.Errorf("Unable to load %v: %v", level.Datafile, err)
-				}
-			}
-			if level.Data != nil {
-				req.out <- level.Data
-			}
-		}
-		close(req.out)
-	}
-}
-
-/* load static star data from tsv file */
+/*
+load static star data from tsv file with id, hipparcos number, name, right
+ascension, declination and magnitude columns. proper motion in right
+ascension and declination, parallax and radial velocity may follow
+*/
 func LoadData(datafile string) (Stardata, error) {
 	f, err := os.Open(datafile)
 	if err != nil {
@@ -180,7 +437,7 @@ func LoadData(datafile string) (Stardata, error) {
 		star := new(Star)
 		line := scanner.Text()
 		parts := strings.Split(line, "\t")
-		if len(parts) != 6 {
+		if len(parts) < 6 || len(parts) == 7 || len(parts) > 10 {
 			continue
 		}
 		//id, idErr := strconv.ParseInt(parts[0], 10, 32)
@@ -189,7 +446,8 @@ func LoadData(datafile string) (Stardata, error) {
 			hip = 0
 		}
 		star.HipNum = int32(hip)
-		star.Name = parts[2]
+		/* unnamed stars have a single space */
+		star.Name = strings.TrimSpace(parts[2])
 		ra, raErr := strconv.ParseFloat(parts[3], 64)
 		dec, decErr := strconv.ParseFloat(parts[4], 64)
 		mag, magErr := strconv.ParseFloat(parts[5], 64)
@@ -197,6 +455,9 @@ func LoadData(datafile string) (Stardata, error) {
 			continue
 		}
 		star.Magnitude = mag
+		if !parseMotion(star, parts[6:]) {
+			continue
+		}
 		coord := geom.NewPoint2D(ra, dec)
 		star.GeoHash = coord.GeoHash(geom.STELLAR)
 		rval = append(rval, star)
@@ -204,3 +465,25 @@ func LoadData(datafile string) (Stardata, error) {
 	sort.Sort(rval)
 	return rval, nil
 }
+
+/*
+takes in optional catalog columns after magnitude: proper motion in right
+ascension and declination, parallax and radial velocity
+sets motion of star, returns false if any column is malformed
+*/
+func parseMotion(star *Star, cols []string) bool {
+	fields := []*float64{&star.PMRA, &star.PMDec, &star.Parallax,
+		&star.RadialVelocity}
+	for i, col := range cols {
+		col = strings.TrimSpace(col)
+		if col == "" {
+			continue
+		}
+		val, err := strconv.ParseFloat(col, 64)
+		if err != nil {
+			return false
+		}
+		*fields[i] = val
+	}
+	return true
+}
@@ -4,12 +4,16 @@ import (
 	"bufio"
 	"geom"
 	"image"
+	"image/color"
+	"log"
 	"math"
 	"net/http"
 	"os"
+	"path"
 	"strconv"
 	"strings"
 	"text/template"
+	"time"
 )
 
 var chars image.Image
@@ -21,16 +25,55 @@ var constelErr error
 var featureTemplate *template.Template
 var templateErr error
 
-var starReqChan = make(chan *StarReq)
+var catalog *Catalog
 
-func init() {
-	/* handler() defined below */
-	http.HandleFunc("/", handler)
-	constelData, constelErr = LoadConstellations("data/consts")
-	chars, charsErr = loadChars()
-	featureTemplate, templateErr =
-		template.ParseFiles("templates/getfeatureinfo.template")
-	go starReqHandler(starReqChan)
+/* logging interface, satisfied by appengine.Context */
+type Logger interface {
+	Infof(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+/* logger that writes to the standard log package */
+type stdLogger struct{}
+
+/* see Logger interface */
+func (l stdLogger) Infof(format string, args ...interface{}) {
+	log.Printf("INFO: "+format, args...)
+}
+
+/* see Logger interface */
+func (l stdLogger) Errorf(format string, args ...interface{}) {
+	log.Printf("ERROR: "+format, args...)
+}
+
+/* returns logger for request, replaced by deployment specific setup */
+var requestLogger = func(r *http.Request) Logger {
+	return stdLogger{}
+}
+
+/*
+load static data from data and template directories
+star catalog tiers are loaded on first use, see Catalog.LoadAll()
+must be called once before serving requests.
+returns an error if constellations or templates can't be loaded
+*/
+func Load(dataDir, templateDir string) error {
+	catalog = NewCatalog(dataDir)
+	constelData, constelErr = LoadConstellations(path.Join(dataDir, "consts"),
+		constellationEpoch, CatalogEpoch)
+	chars, charsErr = loadChars(path.Join(dataDir, "chars.png"))
+	featureTemplate, templateErr = template.ParseFiles(
+		path.Join(templateDir, "getfeatureinfo.template"))
+	if templateErr == nil {
+		templateErr = loadCapabilities(templateDir)
+	}
+	if charsErr != nil {
+		log.Printf("Unable to load label characters: %v", charsErr)
+	}
+	if constelErr != nil {
+		return constelErr
+	}
+	return templateErr
 }
 
 /* common request parameters */
@@ -38,38 +81,237 @@ type Req struct {
 	httpr  *http.Request
 	Width  int
 	Height int
+	/* right ascension hours and declination degrees, or longitude and
+	latitude degrees for rotated CRS frames */
 	Lower  *geom.Point
 	Upper  *geom.Point
-	Layer  string
+	CRS    *SkyCRS
+	Layers []string
+	/* same length as Layers, empty for default style */
+	Styles []string
+	Format string
+	/* jpeg quality 1-100 */
+	Quality int
+	/* draw layers over transparent background instead of BGColor */
+	Transparent bool
+	BGColor     color.Color
+	/* name in projectionRegistry, empty for plate carree */
+	Projection string
+	/* julian date star positions are moved to, see Star.Position() */
+	Epoch float64
+	/* nil unless the sky is seen from the observer's horizon */
+	Observer *Observer
+	/* faintest magnitude drawn, +Inf for every star in the tiers drawn */
+	MaxMag float64
+	/* time the sun, moon and planets are drawn for */
+	Time time.Time
 }
 
-/* returns gets zoom scale for request */
+/* returns gets zoom scale for request in hours per pixel */
 func (r *Req) Scale() float64 {
-	return math.Abs(r.Upper.X()-r.Lower.X()) / float64(r.Width)
+	return r.Trans(geom.STELLAR).Dx
+}
+
+/* returns style requested for layer, empty for the default style or if
+layer isn't requested */
+func (r *Req) layerStyle(layer string) string {
+	for i, name := range r.Layers {
+		if strings.EqualFold(name, layer) && i < len(r.Styles) {
+			return r.Styles[i]
+		}
+	}
+	return ""
+}
+
+/* returns true if layer is requested */
+func (r *Req) hasLayer(layer string) bool {
+	for _, name := range r.Layers {
+		if strings.EqualFold(name, layer) {
+			return true
+		}
+	}
+	return false
 }
 
 /* gets a point transform for request */
 func (r *Req) Trans(gd *geom.GridDef) *geom.PointTransform {
-	return geom.CreateTransform(r.Lower, r.Upper, r.Width, r.Height, gd)
+	return r.transform(r.Lower, r.Upper, gd)
+}
+
+/* gets a point transform for bounds using the request crs and projection */
+func (r *Req) transform(lower, upper *geom.Point,
+	gd *geom.GridDef) *geom.PointTransform {
+	if r.CRS != nil && r.CRS.Rotated() {
+		/* linear in the frame unless a projection is requested */
+		rval := geom.CreateFrameTransform(lower, upper, r.Width, r.Height, gd,
+			r.CRS.frame, projectionRegistry[r.Projection])
+		if r.CRS.horizon {
+			rval.Refract(r.Observer.Atmosphere)
+			rval.HideBelow(0)
+		}
+		return rval
+	}
+	if newProj, ok := projectionRegistry[r.Projection]; ok {
+		return geom.CreateProjectedTransform(lower, upper, r.Width, r.Height,
+			gd, newProj)
+	}
+	return geom.CreateTransform(lower, upper, r.Width, r.Height, gd)
 }
 
-/* get the bounds of the request */
+/* get the bounds of the sky drawn for the request, projections
+can show more than the requested bounds */
 func (r *Req) BBox() *geom.BoundingBox {
-	return geom.NewBBox2D(r.Lower.X(), r.Lower.Y(), r.Upper.X(), r.Upper.Y())
+	return r.Trans(geom.STELLAR).Bounds()
 }
 
-/* parse common request parameters */
-func ParseReq(r *http.Request) *Req {
-	width := intParam("WIDTH", 1024, r)
-	height := intParam("HEIGHT", 512, r)
-	lower, upper := parseBbox("BBOX", r)
-	layer := strParam("LAYERS", "stars", r)
-	return &Req{r, width, height, lower, upper, layer}
+/* get the parts of the request bounds inside the sky, split at 0h */
+func (r *Req) Parts() []*geom.BBoxPart {
+	bbox := r.BBox()
+	lower, upper := bbox.Lower(), bbox.Upper()
+	return geom.SplitBBox2D(lower.X(), lower.Y(), upper.X(), upper.Y(),
+		geom.STELLAR)
+}
+
+/* gets a point transform from sky coordinates in part to the image */
+func (r *Req) PartTrans(part *geom.BBoxPart,
+	gd *geom.GridDef) *geom.PointTransform {
+	trans := r.Trans(gd)
+	if trans.Projected() {
+		/* projections wrap on their own */
+		return trans
+	}
+	lower := geom.NewPoint2D(r.Lower.X()-part.Shift, r.Lower.Y())
+	upper := geom.NewPoint2D(r.Upper.X()-part.Shift, r.Upper.Y())
+	return r.transform(lower, upper, gd)
+}
+
+/* largest image dimensions that will be rendered */
+const (
+	maxWidth  = 4096
+	maxHeight = 4096
+)
+
+/* parse and validate common request parameters */
+func ParseReq(r *http.Request) (*Req, error) {
+	if err := checkVersion(r); err != nil {
+		return nil, err
+	}
+	width, err := sizeParam("WIDTH", 1024, maxWidth, r)
+	if err != nil {
+		return nil, err
+	}
+	height, err := sizeParam("HEIGHT", 512, maxHeight, r)
+	if err != nil {
+		return nil, err
+	}
+	observer, err := observerParam(r)
+	if err != nil {
+		return nil, err
+	}
+	crs, err := crsParam(r, observer)
+	if err != nil {
+		return nil, err
+	}
+	lower, upper, err := parseBbox("BBOX", crs, r)
+	if err != nil {
+		return nil, err
+	}
+	layers, styles, err := parseLayers(strParam("LAYERS", "stars", r),
+		r.FormValue("STYLES"))
+	if err != nil {
+		return nil, err
+	}
+	/* base layers are opaque unless asked otherwise */
+	transparent, err := boolParam("TRANSPARENT",
+		!findLayer(layers[0]).Opaque, r)
+	if err != nil {
+		return nil, err
+	}
+	bgcolor, err := colorParam("BGCOLOR", color.Black, r)
+	if err != nil {
+		return nil, err
+	}
+	format := strParam("FORMAT", "image/png", r)
+	quality, err := sizeParam("QUALITY", defaultQuality, 100, r)
+	if err != nil {
+		return nil, err
+	}
+	projection, err := projectionParam("PROJECTION", r)
+	if err != nil {
+		return nil, err
+	}
+	if observer != nil && r.FormValue("PROJECTION") == "" {
+		/* all sky dome centered on the zenith */
+		projection = domeProjection
+	}
+	epoch, err := epochParam("EPOCH", r)
+	if err != nil {
+		return nil, err
+	}
+	maxMag, err := maxMagParam(r)
+	if err != nil {
+		return nil, err
+	}
+	var t time.Time
+	if observer != nil {
+		t = observer.Time
+	} else if t, err = timeParam("TIME", r); err != nil {
+		return nil, err
+	}
+	return &Req{httpr: r, Width: width, Height: height, Lower: lower,
+		Upper: upper, CRS: crs, Layers: layers, Styles: styles, Format: format,
+		Quality: quality, Transparent: transparent, BGColor: bgcolor,
+		Projection: projection, Epoch: epoch, Observer: observer,
+		MaxMag: maxMag, Time: t}, nil
+}
+
+/* return error if VERSION parameter is present and not supported */
+func checkVersion(r *http.Request) error {
+	version := r.FormValue("VERSION")
+	switch version {
+	case "", wms_1_3_0, wms_1_1_1, "1.1.0", "1.0.0":
+		return nil
+	}
+	return serviceErr(InvalidParameterValue,
+		"Unsupported VERSION: %v", version)
+}
+
+/* split comma separated layer and style lists, layers are returned with
+their registered names. return error if any layer isn't registered or any
+style isn't defined for its layer. styles may be empty for default styles */
+func parseLayers(layerList, styleList string) ([]string, []string, error) {
+	layers := strings.Split(layerList, ",")
+	styles := make([]string, len(layers))
+	if styleList != "" {
+		styles = strings.Split(styleList, ",")
+		if len(styles) != len(layers) {
+			return nil, nil, serviceErr(StyleNotDefined,
+				"STYLES must have one entry per layer")
+		}
+	}
+	for i, name := range layers {
+		layer := findLayer(name)
+		if layer == nil {
+			return nil, nil, serviceErr(LayerNotDefined, "Unknown layer: %v",
+				name)
+		}
+		if !layer.HasStyle(styles[i]) {
+			return nil, nil, serviceErr(StyleNotDefined,
+				"Unknown style %v for layer %v", styles[i], name)
+		}
+		layers[i] = layer.Name
+	}
+	return layers, styles, nil
+}
+
+/* load all star catalog tiers instead of waiting for first use */
+func Preload() error {
+	return catalog.LoadAll(stdLogger{})
 }
 
 /* load character map image */
-func loadChars() (image.Image, error) {
-	f, err := os.Open("data/chars.png")
+func loadChars(charsFile string) (image.Image, error) {
+	f, err := os.Open(charsFile)
 	if err != nil {
 		return nil, err
 	}
@@ -81,11 +323,6 @@ func loadChars() (image.Image, error) {
 	return rval, nil
 }
 
-/* send HTTP error response */
-func doErr(w http.ResponseWriter, err error) {
-	http.Error(w, err.Error(), http.StatusInternalServerError)
-}
-
 /* get string url parameter with default */
 func strParam(key, defaultValue string, r *http.Request) string {
 	rval := r.FormValue(key)
@@ -96,70 +333,142 @@ func strParam(key, defaultValue string, r *http.Request) string {
 }
 
 /* parse integer url parameter
-return defaultValue if parameter isn't present or is malformed */
-func intParam(key string, defaultValue int, r *http.Request) int {
+return defaultValue if parameter isn't present, error if malformed */
+func intParam(key string, defaultValue int, r *http.Request) (int, error) {
 	value := r.FormValue(key)
-	rval := defaultValue
-	if value != "" {
-		tmp, err := strconv.ParseInt(value, 10, 32)
-		if err == nil {
-			rval = int(tmp)
-		}
+	if value == "" {
+		return defaultValue, nil
 	}
-	return rval
+	rval, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return 0, serviceErr(InvalidParameterValue,
+			"%v must be an integer: %v", key, value)
+	}
+	return int(rval), nil
+}
+
+/* returns false for NaN and infinities, which strconv.ParseFloat accepts */
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+/* parse boolean TRUE/FALSE url parameter
+return defaultValue if parameter isn't present, error if malformed */
+func boolParam(key string, defaultValue bool, r *http.Request) (bool, error) {
+	value := r.FormValue(key)
+	if value == "" {
+		return defaultValue, nil
+	} else if strings.EqualFold(value, "TRUE") {
+		return true, nil
+	} else if strings.EqualFold(value, "FALSE") {
+		return false, nil
+	}
+	return false, serviceErr(InvalidParameterValue,
+		"%v must be TRUE or FALSE: %v", key, value)
+}
+
+/* parse hexadecimal 0xRRGGBB color url parameter
+return defaultValue if parameter isn't present, error if malformed */
+func colorParam(key string, defaultValue color.Color, r *http.Request) (
+	color.Color, error) {
+	value := r.FormValue(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	hex := strings.TrimPrefix(strings.ToLower(value), "0x")
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return nil, serviceErr(InvalidParameterValue,
+			"%v must be in the form 0xRRGGBB: %v", key, value)
+	}
+	return color.RGBA{uint8(rgb >> 16), uint8(rgb >> 8), uint8(rgb), 255},
+		nil
 }
 
-/* parse float from string value
-return defaultValue if value is malformed */
-func parseFloat(value string, defaultValue float64) float64 {
-	rval, err := strconv.ParseFloat(value, 64)
+/* parse image size url parameter
+return defaultValue if parameter isn't present,
+error if malformed or not between 1 and max */
+func sizeParam(key string, defaultValue, max int, r *http.Request) (int,
+	error) {
+	rval, err := intParam(key, defaultValue, r)
 	if err != nil {
-		rval = defaultValue
+		return 0, err
 	}
-	return rval
+	if rval < 1 || rval > max {
+		return 0, serviceErr(InvalidParameterValue,
+			"%v must be between 1 and %v: %v", key, max, rval)
+	}
+	return rval, nil
 }
 
-/* parse bounding box url parameter
-return full bounds if parameter isn't present or is malformed */
-func parseBbox(key string, r *http.Request) (*geom.Point, *geom.Point) {
-	leftx := 24.0
-	lowery := -90.0
-	rightx := 0.0
-	uppery := 90.0
+/* parse bounding box url parameter in crs, converting to right ascension
+hours unless crs is a rotated frame. follows WMS 1.3.0 axis order
+return full bounds if parameter isn't present, error if malformed */
+func parseBbox(key string, crs *SkyCRS, r *http.Request) (*geom.Point,
+	*geom.Point, error) {
+	/* hours in a full circle of right ascension or degrees of longitude */
+	period := 24.0
+	if crs.Rotated() {
+		period = 360
+	}
 	value := r.FormValue(key)
-	if value != "" {
-		parts := strings.Split(value, ",")
-		if len(parts) == 4 {
-			x0 := parseFloat(parts[0], leftx)
-			y0 := parseFloat(parts[1], lowery)
-			x1 := parseFloat(parts[2], rightx)
-			y1 := parseFloat(parts[3], uppery)
-			/* in stellar coordinates, 24 is left of 0 */
-			if x0 > x1 {
-				leftx = x0
-				rightx = x1
-			} else {
-				leftx = x1
-				rightx = x0
-			}
-			if y0 > y1 {
-				lowery = y1
-				uppery = y0
-			} else {
-				lowery = y0
-				uppery = y1
-			}
+	if value == "" && crs.horizon {
+		/* sky above the horizon with north at the top */
+		return geom.NewPoint2D(period, 0), geom.NewPoint2D(0, 90), nil
+	} else if value == "" {
+		return geom.NewPoint2D(period, -90), geom.NewPoint2D(0, 90), nil
+	}
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return nil, nil, serviceErr(InvalidParameterValue,
+			"%v must have 4 values: %v", key, value)
+	}
+	vals := make([]float64, 4)
+	for i, part := range parts {
+		val, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || !finite(val) {
+			return nil, nil, serviceErr(InvalidParameterValue,
+				"%v values must be numbers: %v", key, value)
 		}
+		vals[i] = val
+	}
+	x0, y0, x1, y1 := vals[0], vals[1], vals[2], vals[3]
+	if crs.LatFirst && r.FormValue("VERSION") == wms_1_3_0 {
+		x0, y0, x1, y1 = vals[1], vals[0], vals[3], vals[2]
+	}
+	if x0 == x1 || y0 >= y1 {
+		return nil, nil, serviceErr(InvalidParameterValue,
+			"%v must have minimums less than maximums: %v", key, value)
+	}
+	if !crs.Rotated() {
+		x0, y0 = crs.toHours(x0, y0)
+		x1, y1 = crs.toHours(x1, y1)
 	}
-	return geom.NewPoint2D(leftx, lowery), geom.NewPoint2D(rightx, uppery)
+	/* right ascension may run past 0h or 24h when wrapping across the
+	seam, but the box can't be wider than the sky */
+	if x0 < -period || x0 > 2*period || x1 < -period || x1 > 2*period ||
+		math.Abs(x1-x0) > period || y0 < -90 || y1 > 90 {
+		return nil, nil, serviceErr(InvalidParameterValue,
+			"%v outside of sky bounds: %v", key, value)
+	}
+	/* in stellar coordinates, 24 is left of 0 */
+	leftx, rightx := math.Max(x0, x1), math.Min(x0, x1)
+	return geom.NewPoint2D(leftx, y0), geom.NewPoint2D(rightx, y1), nil
 }
 
 /* top level WMS request handler */
-func handler(w http.ResponseWriter, r *http.Request) {
+func Handler(w http.ResponseWriter, r *http.Request) {
 	request := r.FormValue("REQUEST")
 	if strings.EqualFold(request, "GETFEATUREINFO") {
 		getfeatureinfo(w, r)
-	} else {
+	} else if strings.EqualFold(request, "GETCAPABILITIES") {
+		getcapabilities(w, r)
+	} else if strings.EqualFold(request, "GETRISESET") {
+		getriseset(w, r)
+	} else if request == "" || strings.EqualFold(request, "GETMAP") {
 		getmap(w, r)
+	} else {
+		doErr(w, r, serviceErr(OperationNotSupported,
+			"Unsupported REQUEST: %v", request))
 	}
 }
@@ -1,14 +1,27 @@
 package starmap
 
 import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
 	"geom"
+	"image"
 	"image/color"
 	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
 	"image/png"
+	"io/ioutil"
+	"math"
+	"net/http/httptest"
 	"os"
 	"render"
 	"render/style"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestPrefix(t *testing.T) {
@@ -29,22 +42,145 @@ func TestPrefix(t *testing.T) {
 	}
 }
 
-/*
 func TestClosest(t *testing.T) {
 	data, err := LoadData("../data/bright.tsv")
 	if err != nil {
 		t.Errorf("Can't load test data: %v", err)
 	}
-	//p := geom.NewPoint2D(14.579589, 25.817871)
 	p := geom.NewPoint2D(14.7249, 26.5155)
-	res := FindClosest(p)
-	if res == nil || res.GeoHash != "ehdyym3b" {
+	res := FindClosest([]*Tier{NewTier(data)}, p)
+	if res == nil {
 		t.Errorf("no point found")
 	} else if res.GeoHash != "ehdyym3b" {
 		p, _ = geom.UnHash(res.GeoHash, geom.STELLAR)
-		t.Errorf("Expected , got %v", p)
+		t.Errorf("Expected ehdyym3b, got %v %v", res.GeoHash, p)
 	}
-}*/
+}
+
+func TestCatalogTiers(t *testing.T) {
+	c := NewCatalog("../data")
+	tiers := c.Tiers(stdLogger{}, 2)
+	if len(tiers) != 2 {
+		t.Fatalf("expected 2 tiers, got %v", len(tiers))
+	}
+	/* dimmest tier comes first */
+	if len(tiers[0].Stars) <= len(tiers[1].Stars) {
+		t.Errorf("expected tier2 before bright, got %v %v",
+			len(tiers[0].Stars), len(tiers[1].Stars))
+	}
+	again := c.Tiers(stdLogger{}, 1)
+	if len(again) != 1 || again[0] != tiers[1] {
+		t.Errorf("expected bright tier to be loaded once")
+	}
+	/* unnamed stars have no name parameter rather than a blank one */
+	for _, star := range tiers[1].Stars {
+		if star.Name == "" {
+			for _, p := range asParams(star, CatalogEpoch) {
+				if p.Key == "name" {
+					t.Errorf("expected no name for %v, got %q", star.HipNum,
+						p.Val)
+				}
+			}
+			break
+		} else if star.Name != strings.TrimSpace(star.Name) {
+			t.Errorf("expected trimmed name, got %q", star.Name)
+		}
+	}
+}
+
+/* renders full resolution tiles from many goroutines at once */
+func BenchmarkParallelStarTiles(b *testing.B) {
+	catalog = NewCatalog("../data")
+	if err := catalog.LoadAll(stdLogger{}); err != nil {
+		b.Fatalf("Can't load catalog: %v", err)
+	}
+	req := &Req{Width: 256, Height: 256, Lower: geom.NewPoint2D(6, 0),
+		Upper: geom.NewPoint2D(5.625, 22.5), Layers: []string{"stars"},
+		Epoch: CatalogEpoch, MaxMag: math.Inf(1)}
+	b.SetParallelism(8)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			img := render.Create(req.Width, req.Height, color.Black)
+			if err := createStarTile(img, req); err != nil {
+				b.Errorf("Can't create tile: %v", err)
+			}
+		}
+	})
+}
+
+/* tile straddling 12h and the celestial equator */
+var straddleLower, straddleUpper = geom.NewPoint2D(12.1875, -2.8125),
+	geom.NewPoint2D(11.8125, 2.8125)
+
+func BenchmarkScanSingleRange(b *testing.B) {
+	data := loadBenchData(b)
+	b.ResetTimer()
+	scanned := 0
+	for i := 0; i < b.N; i += 1 {
+		lowerHash, upperHash := geom.BBoxHash(straddleLower, straddleUpper,
+			geom.STELLAR)
+		scanned += len(data.Range(lowerHash, upperHash))
+	}
+	b.ReportMetric(float64(scanned)/float64(b.N), "stars/tile")
+}
+
+func BenchmarkScanCover(b *testing.B) {
+	data := loadBenchData(b)
+	b.ResetTimer()
+	scanned := 0
+	for i := 0; i < b.N; i += 1 {
+		ranges := geom.BBoxCover(straddleLower, straddleUpper, coverPrecision,
+			geom.STELLAR)
+		for _, found := range data.Ranges(ranges) {
+			scanned += len(found)
+		}
+	}
+	b.ReportMetric(float64(scanned)/float64(b.N), "stars/tile")
+}
+
+func loadBenchData(b *testing.B) Stardata {
+	data, err := LoadData("../data/tier2.tsv")
+	if err != nil {
+		b.Fatalf("Can't load test data: %v", err)
+	}
+	return data
+}
+
+func TestQuery(t *testing.T) {
+	data, err := LoadData("../data/bright.tsv")
+	if err != nil {
+		t.Fatalf("Can't load test data: %v", err)
+	}
+	lower, upper := geom.NewPoint2D(24, -5), geom.NewPoint2D(23.9, -2)
+	trans := geom.CreateTransform(lower, upper, 256, 256, geom.STELLAR)
+	bbox := geom.NewBBox2D(23.9, -5, 24, -2)
+	/* hip 145 is at 0.0304h, about 78 pixels past the edge at 0h */
+	if hasHip(data.Query(bbox, trans, 4), 145) {
+		t.Errorf("hip 145 shouldn't be inside 4 pixel margin")
+	}
+	stars := data.Query(bbox, trans, 100)
+	if !hasHip(stars, 145) {
+		t.Errorf("expected hip 145 inside 100 pixel margin, got %v", stars)
+	}
+	for _, s := range stars {
+		coord, _ := geom.UnHash(s.GeoHash, geom.STELLAR)
+		x := geom.STELLAR.NearestX(coord.X(), 23.95)
+		pix := trans.TransformXY(x, coord.Y())
+		if pix.X < -100 || pix.X > 356 || pix.Y < -100 || pix.Y > 356 {
+			t.Errorf("%v at %v is outside of margin", s.HipNum, pix)
+		}
+	}
+}
+
+func hasHip(stars Stardata, hip int32) bool {
+	for _, s := range stars {
+		if s.HipNum == hip {
+			return true
+		}
+	}
+	return false
+}
 
 func TestFilter(t *testing.T) {
 	lower := geom.NewPoint2D(24, -90)
@@ -94,7 +230,8 @@ func TestReadPoly(t *testing.T) {
 }
 
 func TestConstFilter(t *testing.T) {
-	data, err := LoadConstellations("../data/consts")
+	data, err := LoadConstellations("../data/consts", constellationEpoch,
+		CatalogEpoch)
 	if err != nil || len(data) < 1 {
 		t.Errorf("loading: %v", err)
 	}
@@ -137,3 +274,1053 @@ func TestReadString(t *testing.T) {
 	}
 	t.Errorf("%v", seqs)
 }
+
+func TestCapabilities(t *testing.T) {
+	if err := loadCapabilities("../templates"); err != nil {
+		t.Fatalf("can't load templates: %v", err)
+	}
+	for _, version := range []string{"1.1.1", "1.3.0"} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET",
+			"/wms?REQUEST=GetCapabilities&VERSION="+version, nil)
+		Handler(w, r)
+		var doc struct {
+			Version string   `xml:"version,attr"`
+			Names   []string `xml:"Capability>Layer>Layer>Name"`
+		}
+		if err := xml.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+			t.Errorf("invalid %v capabilities: %v", version, err)
+		}
+		if doc.Version != version {
+			t.Errorf("expected version %v, got %v", version, doc.Version)
+		}
+		if len(doc.Names) != len(layerRegistry) {
+			t.Errorf("expected layers %v, got %v", len(layerRegistry),
+				doc.Names)
+		}
+	}
+}
+
+func TestParseReqErrors(t *testing.T) {
+	tests := map[string]string{
+		"LAYERS=stars":                          "",
+		"LAYERS=stars,nebulae":                  LayerNotDefined,
+		"LAYERS=stars&STYLES=default":           "",
+		"LAYERS=stars&STYLES=fancy":             StyleNotDefined,
+		"LAYERS=stars,asterisms&STYLES=default": StyleNotDefined,
+		"WIDTH=-5":                              InvalidParameterValue,
+		"HEIGHT=100000":                         InvalidParameterValue,
+		"WIDTH=12.5":                            InvalidParameterValue,
+		"BBOX=6,0,5,nope":                       InvalidParameterValue,
+		"BBOX=NaN,0,1,1":                        InvalidParameterValue,
+		"BBOX=0,-Inf,1,1":                       InvalidParameterValue,
+		"BBOX=6,10,5,0":                         InvalidParameterValue,
+		"BBOX=6,-100,5,0":                       InvalidParameterValue,
+		"BBOX=6,0,5,22.5&WIDTH=256&HEIGHT=256":  "",
+		"BBOX=-1,0,1,10":                        "",
+		"BBOX=23,0,25,10":                       "",
+		"BBOX=-1,0,24,10":                       InvalidParameterValue,
+		"BBOX=-30,0,-25,10":                     InvalidParameterValue,
+		"PROJECTION=Mollweide":                  "",
+		"PROJECTION=mercator":                   InvalidParameterValue,
+		"SRS=EPSG:3857":                         InvalidCRS,
+		"CRS=STARMAP:GALACTIC&BBOX=-10,0,10,5":  "",
+		"CRS=STARMAP:GALACTIC&BBOX=0,0,400,5":   InvalidParameterValue,
+		"VERSION=2.0.0":                         InvalidParameterValue,
+		"LAYERS=grid&STYLES=galactic":           "",
+		"LAYERS=grid&STYLES=horizon":            StyleNotDefined,
+		"EPOCH=J2100.5":                         "",
+		"EPOCH=B1950":                           "",
+		"EPOCH=soon":                            InvalidParameterValue,
+		"EPOCH=9000":                            InvalidParameterValue,
+		"EPOCH=NaN":                             InvalidParameterValue,
+		"EPOCH=JNaN":                            InvalidParameterValue,
+		"LAT=40&LON=-75&TIME=2024-01-15T03:00Z": "",
+		"LAT=40&LON=-75&BBOX=90,0,270,45":       "",
+		"LAT=40":                                MissingParameterValue,
+		"LAT=95&LON=0":                          InvalidParameterValue,
+		"LAT=NaN&LON=0":                         InvalidParameterValue,
+		"LAT=40&LON=nan":                        InvalidParameterValue,
+		"LAT=40&LON=-75&TIME=tonight":           InvalidParameterValue,
+		"LAYERS=planets&TIME=2024-01-15":        "",
+		"LAYERS=planets&TIME=tonight":           InvalidParameterValue,
+		"CRS=STARMAP:HORIZON":                   MissingParameterValue,
+		"CRS=STARMAP:GALACTIC&LAT=40&LON=-75":   InvalidCRS,
+		"LAT=40&LON=-75&PRESSURE=0":             "",
+		"LAT=40&LON=-75&PRESSURE=-5":            InvalidParameterValue,
+		"LAT=40&LON=-75&TEMPERATURE=warm":       InvalidParameterValue,
+		"LAT=40&LON=-75&TEMPERATURE=NaN":        InvalidParameterValue,
+		"LAT=40&LON=-75&PRESSURE=NaN":           InvalidParameterValue,
+		"MAXMAG=5.5":                            "",
+		"MAXMAG=faint":                          InvalidParameterValue,
+		"MAXMAG=30":                             InvalidParameterValue,
+		"MAXMAG=NaN":                            InvalidParameterValue,
+		"MAXMAG=Inf":                            InvalidParameterValue,
+		"BORTLE=9":                              "",
+		"BORTLE=0":                              InvalidParameterValue,
+		"MAXMAG=5&BORTLE=3":                     InvalidParameterValue,
+	}
+	for query, code := range tests {
+		r := httptest.NewRequest("GET", "/wms?"+query, nil)
+		_, err := ParseReq(r)
+		if code == "" {
+			if err != nil {
+				t.Errorf("%v: unexpected error %v", query, err)
+			}
+		} else if se, ok := err.(*ServiceError); !ok || se.Code != code {
+			t.Errorf("%v: expected %v, got %v", query, code, err)
+		}
+	}
+}
+
+func TestExceptionReport(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/wms?VERSION=1.1.1&LAYERS=nebulae", nil)
+	Handler(w, r)
+	var report struct {
+		Exception struct {
+			Code string `xml:"code,attr"`
+		} `xml:"ServiceException"`
+	}
+	if err := xml.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Errorf("invalid exception report: %v", err)
+	}
+	if report.Exception.Code != LayerNotDefined {
+		t.Errorf("expected %v, got %v", LayerNotDefined, report.Exception.Code)
+	}
+	if w.Header().Get("Content-Type") != "application/vnd.ogc.se_xml" {
+		t.Errorf("unexpected content type %v", w.Header().Get("Content-Type"))
+	}
+}
+
+func TestQueryLayers(t *testing.T) {
+	catalog = NewCatalog("../data")
+	/* arcturus at the query point */
+	query := "/wms?REQUEST=GetFeatureInfo&WIDTH=100&HEIGHT=100" +
+		"&BBOX=14,14,14.5,24&X=48&Y=48&INFO_FORMAT=text/plain"
+	for params, code := range map[string]string{
+		"&LAYERS=Stars":                             "",
+		"&LAYERS=stars&QUERY_LAYERS=STARS":          "",
+		"&LAYERS=stars&QUERY_LAYERS=constellations": LayerNotDefined,
+		"&LAYERS=stars,grid":                        "",
+		"&LAYERS=stars,grid&QUERY_LAYERS=grid":      LayerNotQueryable,
+	} {
+		w := httptest.NewRecorder()
+		Handler(w, httptest.NewRequest("GET", query+params, nil))
+		var report struct {
+			Exception struct {
+				Code string `xml:"code,attr"`
+			} `xml:"ServiceException"`
+		}
+		xml.Unmarshal(w.Body.Bytes(), &report)
+		if report.Exception.Code != code {
+			t.Errorf("%v: expected %q, got %q", params, code,
+				report.Exception.Code)
+		}
+		if code == "" && !strings.Contains(w.Body.String(), "Arcturus") {
+			t.Errorf("%v: expected arcturus, got %v", params, w.Body.String())
+		}
+	}
+}
+
+func TestCompositeTile(t *testing.T) {
+	catalog = NewCatalog("../data")
+	constelData, constelErr = LoadConstellations("../data/consts",
+		constellationEpoch, CatalogEpoch)
+	tests := map[string]color.RGBA{
+		"LAYERS=stars,constellations":                  {0, 0, 0, 255},
+		"LAYERS=stars,constellations&TRANSPARENT=TRUE": {0, 0, 0, 0},
+		"LAYERS=constellations":                        {0, 0, 0, 0},
+		"LAYERS=constellations&TRANSPARENT=FALSE&BGCOLOR=0xFF0000": {255, 0,
+			0, 255},
+	}
+	for query, exp := range tests {
+		r := httptest.NewRequest("GET",
+			"/wms?WIDTH=64&HEIGHT=64&BBOX=6,60,5,70&"+query, nil)
+		req, err := ParseReq(r)
+		if err != nil {
+			t.Fatalf("%v: can't parse: %v", query, err)
+		}
+		tile, err := createTile(req, findFormat("image/png"))
+		if err != nil {
+			t.Fatalf("%v: can't create tile: %v", query, err)
+		}
+		img, err := png.Decode(bytes.NewReader(tile))
+		if err != nil {
+			t.Fatalf("%v: can't decode tile: %v", query, err)
+		}
+		if res := color.RGBAModel.Convert(img.At(0, 0)); res != exp {
+			t.Errorf("%v: expected background %v, got %v", query, exp, res)
+		}
+	}
+}
+
+func TestWrappedTile(t *testing.T) {
+	catalog = NewCatalog("../data")
+	constelData, constelErr = LoadConstellations("../data/consts",
+		constellationEpoch, CatalogEpoch)
+	query := "/wms?WIDTH=128&HEIGHT=64&LAYERS=stars,constellations&BBOX="
+	var tiles []image.Image
+	/* the same area of sky straddling 0h from both sides of the seam */
+	for _, bbox := range []string{"-1,-10,1,10", "23,-10,25,10"} {
+		r := httptest.NewRequest("GET", query+bbox, nil)
+		req, err := ParseReq(r)
+		if err != nil {
+			t.Fatalf("%v: can't parse: %v", bbox, err)
+		}
+		tile, err := createTile(req, findFormat("image/png"))
+		if err != nil {
+			t.Fatalf("%v: can't create tile: %v", bbox, err)
+		}
+		img, err := png.Decode(bytes.NewReader(tile))
+		if err != nil {
+			t.Fatalf("%v: can't decode tile: %v", bbox, err)
+		}
+		tiles = append(tiles, img)
+	}
+	left, right := 0, 0
+	bounds := tiles[0].Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += 1 {
+		for x := bounds.Min.X; x < bounds.Max.X; x += 1 {
+			c := color.RGBAModel.Convert(tiles[0].At(x, y)).(color.RGBA)
+			if c != color.RGBAModel.Convert(tiles[1].At(x, y)) {
+				t.Fatalf("tiles differ at %v,%v", x, y)
+			}
+			if c.R > 0 {
+				if x < bounds.Dx()/2 {
+					left += 1
+				} else {
+					right += 1
+				}
+			}
+		}
+	}
+	/* left side of the tile is before 0h, right side is after */
+	if left == 0 || right == 0 {
+		t.Errorf("expected drawing on both sides of 0h, got %v and %v",
+			left, right)
+	}
+}
+
+func TestCRSBBox(t *testing.T) {
+	/* all the same box, 10h to 14h and -10 to 10 degrees */
+	tests := []string{
+		"BBOX=14,-10,10,10",
+		"CRS=STARMAP:EQUATORIAL-DEGREES&BBOX=150,-10,210,10",
+		"SRS=EPSG:4326&VERSION=1.1.1&BBOX=-30,-10,30,10",
+		"CRS=EPSG:4326&VERSION=1.3.0&BBOX=-10,-30,10,30",
+		"CRS=CRS:84&VERSION=1.3.0&BBOX=-30,-10,30,10",
+	}
+	for _, query := range tests {
+		req, err := ParseReq(httptest.NewRequest("GET", "/wms?"+query, nil))
+		if err != nil {
+			t.Errorf("%v: can't parse: %v", query, err)
+			continue
+		}
+		if req.Lower.X() != 14 || req.Lower.Y() != -10 ||
+			req.Upper.X() != 10 || req.Upper.Y() != 10 {
+			t.Errorf("%v: expected 14,-10 10,10 got %v %v", query, req.Lower,
+				req.Upper)
+		}
+	}
+	/* polaris is at galactic 123.28, 26.46 */
+	catalog = NewCatalog("../data")
+	w := httptest.NewRecorder()
+	Handler(w, httptest.NewRequest("GET", "/wms?REQUEST=GetFeatureInfo"+
+		"&LAYERS=stars&CRS=STARMAP:GALACTIC&BBOX=113.28,16.46,133.28,36.46"+
+		"&WIDTH=100&HEIGHT=100&I=50&J=50&INFO_FORMAT=text/plain", nil))
+	if !bytes.Contains(w.Body.Bytes(), []byte("Polaris")) {
+		t.Errorf("expected polaris, got %v", w.Body.String())
+	}
+}
+
+func TestProjectedTiles(t *testing.T) {
+	catalog = NewCatalog("../data")
+	constelData, constelErr = LoadConstellations("../data/consts",
+		constellationEpoch, CatalogEpoch)
+	for _, name := range projections() {
+		r := httptest.NewRequest("GET", "/wms?WIDTH=128&HEIGHT=128"+
+			"&LAYERS=stars,constellations&BBOX=24,50,0,90&PROJECTION="+name, nil)
+		w := httptest.NewRecorder()
+		getmap(w, r)
+		if w.Code != 200 {
+			t.Errorf("%v: unexpected status %v: %v", name, w.Code,
+				w.Body.String())
+		}
+	}
+	/* polaris is about 0.74 degrees from the pole at the center */
+	query := "/wms?REQUEST=GetFeatureInfo&LAYERS=stars&WIDTH=100&HEIGHT=100" +
+		"&BBOX=24,80,0,90&PROJECTION=stereographic&INFO_FORMAT=text/plain"
+	r := httptest.NewRequest("GET", query, nil)
+	req, err := ParseReq(r)
+	if err != nil {
+		t.Fatalf("can't parse: %v", err)
+	}
+	pix := req.Trans(geom.STELLAR).TransformXY(2.53, 89.26)
+	w := httptest.NewRecorder()
+	Handler(w, httptest.NewRequest("GET", query+"&X="+
+		strconv.Itoa(pix.X)+"&Y="+strconv.Itoa(pix.Y), nil))
+	if !bytes.Contains(w.Body.Bytes(), []byte("Polaris")) {
+		t.Errorf("expected polaris at %v, got %v", pix, w.Body.String())
+	}
+}
+
+func TestFormats(t *testing.T) {
+	catalog = NewCatalog("../data")
+	sizes := make(map[string]int)
+	for _, mimeType := range mapFormats() {
+		r := httptest.NewRequest("GET", "/wms?WIDTH=256&HEIGHT=256&FORMAT="+
+			mimeType, nil)
+		w := httptest.NewRecorder()
+		getmap(w, r)
+		format := findFormat(mimeType)
+		if w.Header().Get("Content-Type") != format.ContentType {
+			t.Errorf("expected %v, got %v", format.ContentType,
+				w.Header().Get("Content-Type"))
+		}
+		sizes[mimeType] = w.Body.Len()
+		img, _, err := image.Decode(w.Body)
+		if err != nil {
+			t.Errorf("can't decode %v: %v", mimeType, err)
+			continue
+		}
+		if img.Bounds().Dx() != 256 {
+			t.Errorf("expected 256 wide %v, got %v", mimeType, img.Bounds())
+		}
+	}
+	if sizes["image/png8"] >= sizes["image/png"] {
+		t.Errorf("expected png8 smaller than png: %v", sizes)
+	}
+}
+
+func TestFeatureInfoJSON(t *testing.T) {
+	catalog = NewCatalog("../data")
+	/* one pixel per 0.005 hours and 0.1 degrees around arcturus */
+	query := "/wms?REQUEST=GetFeatureInfo&LAYERS=stars&WIDTH=100&HEIGHT=100" +
+		"&BBOX=14,14,14.5,24&X=48&Y=48&INFO_FORMAT="
+	w := httptest.NewRecorder()
+	Handler(w, httptest.NewRequest("GET", query+"application/json", nil))
+	var res struct {
+		Features []struct {
+			Type       string
+			Properties map[string]interface{}
+		}
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("invalid json %v: %v", w.Body.String(), err)
+	}
+	if len(res.Features) != 1 || res.Features[0].Type != "star" {
+		t.Fatalf("expected one star, got %v", res.Features)
+	}
+	props := res.Features[0].Properties
+	if props["hipparcos"] != 69673.0 {
+		t.Errorf("expected hipparcos 69673, got %v", props["hipparcos"])
+	}
+	if _, ok := props["magnitude"].(float64); !ok {
+		t.Errorf("expected numeric magnitude, got %v", props["magnitude"])
+	}
+
+	w = httptest.NewRecorder()
+	Handler(w, httptest.NewRequest("GET", query+"application/geo%2Bjson", nil))
+	var geo struct {
+		Type     string
+		Features []struct {
+			Geometry struct {
+				Type        string
+				Coordinates []float64
+			}
+		}
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &geo); err != nil {
+		t.Fatalf("invalid geojson %v: %v", w.Body.String(), err)
+	}
+	if geo.Type != "FeatureCollection" || len(geo.Features) != 1 ||
+		geo.Features[0].Geometry.Type != "Point" {
+		t.Fatalf("unexpected geojson %v", w.Body.String())
+	}
+	/* arcturus at 14.26h is west of the 12h CRS:84 prime meridian */
+	ra := props["right_ascension"].(float64)
+	pos := geo.Features[0].Geometry.Coordinates
+	if len(pos) != 2 || math.Abs(pos[0]-(12-ra)*15) > 2e-4 ||
+		pos[1] != props["declination"] {
+		t.Errorf("expected arcturus at lon/lat degrees, got %v", pos)
+	}
+}
+
+func TestFindNearest(t *testing.T) {
+	data, err := LoadData("../data/tier2.tsv")
+	if err != nil {
+		t.Fatalf("Can't load test data: %v", err)
+	}
+	tiers := []*Tier{NewTier(data)}
+	lower := geom.NewPoint2D(4, 20)
+	upper := geom.NewPoint2D(3.5, 28)
+	trans := geom.CreateTransform(lower, upper, 64, 64, geom.STELLAR)
+	/* pleiades */
+	p := geom.NewPoint2D(3.79, 24.1)
+	stars := FindNearest(tiers, p, trans, 10, 5, CatalogEpoch)
+	if len(stars) != 5 {
+		t.Fatalf("expected 5 stars, got %v", len(stars))
+	}
+	prev := 0.0
+	for _, s := range stars {
+		coord, _ := geom.UnHash(s.GeoHash, geom.STELLAR)
+		dist := p.AngularDistance(coord)
+		if dist > 10*trans.Dy || dist < prev {
+			t.Errorf("expected sorted within tolerance, got %v after %v",
+				dist, prev)
+		}
+		prev = dist
+	}
+	if len(FindNearest(tiers, p, trans, 0.01, 5, CatalogEpoch)) != 0 {
+		t.Errorf("expected no stars within tiny tolerance")
+	}
+}
+
+func TestFindWithinSeam(t *testing.T) {
+	data, err := LoadData("../data/bright.tsv")
+	if err != nil {
+		t.Fatalf("Can't load test data: %v", err)
+	}
+	/* hip 145 is at 0.0304h, search from the other side of 0h */
+	p := geom.NewPoint2D(23.995, -3)
+	stars := FindWithin([]*Tier{NewTier(data)}, p, 1, 1)
+	if len(stars) != 1 || stars[0].HipNum != 145 {
+		t.Errorf("expected hip 145, got %v", stars)
+	}
+	/* near the pole a wide range of right ascension is close */
+	p = geom.NewPoint2D(12, 89.5)
+	stars = FindWithin([]*Tier{NewTier(data)}, p, 2, 1)
+	if len(stars) != 1 || stars[0].Name != "Polaris" {
+		t.Errorf("expected polaris, got %v", stars)
+	}
+}
+
+func TestQueryFrame(t *testing.T) {
+	data, err := LoadData("../data/bright.tsv")
+	if err != nil {
+		t.Fatalf("Can't load test data: %v", err)
+	}
+	boxes := []*geom.BoundingBox{
+		/* around the galactic center, across 0 longitude */
+		geom.NewBBox2D(-10, -10, 10, 10),
+		/* around polaris and the celestial pole */
+		geom.NewBBox2D(115, 20, 130, 35),
+	}
+	for _, box := range boxes {
+		found := data.QueryFrame(geom.GalacticFrame, box)
+		if len(found) == 0 {
+			t.Errorf("no stars found in %v", box)
+		}
+		lower, upper := box.Lower(), box.Upper()
+		expected := 0
+		for _, s := range data {
+			coord, _ := geom.UnHash(s.GeoHash, geom.STELLAR)
+			p := coord.ToGalactic()
+			l := geom.GALACTIC.NearestX(p.X(), (lower.X()+upper.X())/2)
+			if l >= lower.X() && l <= upper.X() &&
+				p.Y() >= lower.Y() && p.Y() <= upper.Y() {
+				expected += 1
+			}
+		}
+		if len(found) != expected {
+			t.Errorf("expected %v stars in %v, got %v", expected, box,
+				len(found))
+		}
+	}
+	if !hasHip(data.QueryFrame(geom.GalacticFrame, boxes[1]), 11767) {
+		t.Errorf("expected polaris in %v", boxes[1])
+	}
+}
+
+func TestConstEpoch(t *testing.T) {
+	data, err := LoadConstellations("../data/consts", geom.J2000, geom.B1875)
+	if err != nil {
+		t.Fatalf("loading: %v", err)
+	}
+	for _, c := range data {
+		if c.Name != "Aquila" {
+			continue
+		}
+		/* first vertex is 18h35m -3 degrees in B1875 */
+		first := c.PolyInfos[0].Geom.Coords().Get(0)
+		if math.Abs(first[0]-18.583333) > 0.0001 ||
+			math.Abs(first[1]+3) > 0.0001 {
+			t.Errorf("expected aquila boundary in B1875, got %v", first)
+		}
+		return
+	}
+	t.Errorf("aquila not loaded")
+}
+
+func TestProperMotion(t *testing.T) {
+	f, err := ioutil.TempFile("", "motion")
+	if err != nil {
+		t.Fatalf("Can't create test data: %v", err)
+	}
+	defer os.Remove(f.Name())
+	/* barnard's star with hipparcos motion, arcturus without */
+	fmt.Fprintf(f, "63339\t87937\tBarnard's Star\t17.96360153\t4.66828815"+
+		"\t9.55\t-797.84\t10326.93\t549.01\t-110.6\n")
+	fmt.Fprintf(f, "50628\t69673\tArcturus\t14.2612076\t19.18726997\t-0.1\n")
+	fmt.Fprintf(f, "1\t2\tBroken\t1\t2\t3\tfast\t4\n")
+	f.Close()
+	data, err := LoadData(f.Name())
+	if err != nil || len(data) != 2 {
+		t.Fatalf("expected 2 stars, got %v: %v", len(data), err)
+	}
+	var barnard *Star
+	for _, s := range data {
+		if s.HipNum == 87937 {
+			barnard = s
+		}
+	}
+	if barnard == nil || !barnard.Moves() {
+		t.Fatalf("expected barnard's star to move, got %v", barnard)
+	}
+	start, _ := barnard.Position(CatalogEpoch)
+	end, _ := barnard.Position(geom.JulianEpoch(2100))
+	/* about 10.3 arc seconds north each year */
+	if dy := (end.Y() - start.Y()) * 3600; dy < 1030 || dy > 1040 {
+		t.Errorf("expected about 1033 arc seconds north, got %v", dy)
+	}
+	/* and 0.8 arc seconds west */
+	dx := (end.X() - start.X()) * geom.DegreesPerHour * 3600 *
+		math.Cos(start.Y()*math.Pi/180)
+	if dx > -79 || dx < -81 {
+		t.Errorf("expected about 80 arc seconds west, got %v", dx)
+	}
+	tiers := []*Tier{NewTier(data)}
+	if len(FindWithinEpoch(tiers, end, 0.01, 1, CatalogEpoch)) != 0 {
+		t.Errorf("barnard's star shouldn't be at its J2100 position in J2000")
+	}
+	found := FindWithinEpoch(tiers, end, 0.01, 1, geom.JulianEpoch(2100))
+	if len(found) != 1 || found[0] != barnard {
+		t.Errorf("expected barnard's star at its J2100 position, got %v",
+			found)
+	}
+}
+
+func TestCatalogMotion(t *testing.T) {
+	find := func(file string, hip int32) (Stardata, *Star) {
+		data, err := LoadData(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range data {
+			if s.HipNum == hip {
+				return data, s
+			}
+		}
+		t.Fatalf("hip %v not in %v", hip, file)
+		return nil, nil
+	}
+	data, barnard := find("../data/tier4.tsv", 87937)
+	if !barnard.Moves() {
+		t.Fatalf("expected barnard's star to move")
+	}
+	start, _ := barnard.Position(CatalogEpoch)
+	end, _ := barnard.Position(geom.JulianEpoch(2100))
+	if dy := (end.Y() - start.Y()) * 3600; dy < 1030 || dy > 1040 {
+		t.Errorf("expected about 1033 arc seconds north, got %v", dy)
+	}
+	tier := NewTier(data)
+	/* barnard's star is the fastest and speeds up as it gets closer */
+	if tier.Motion < 10.3 || tier.Motion > 10.4 {
+		t.Errorf("expected barnard's motion for tier4, got %v", tier.Motion)
+	}
+	margin := tier.motionMargin(geom.JulianEpoch(4000))
+	if margin < 5.75 || margin > 7 {
+		t.Errorf("expected a margin past 5.75 degrees by 4000, got %v", margin)
+	}
+	still, err := LoadData("../data/tier3.tsv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := NewTier(still).motionMargin(geom.JulianEpoch(4000)); m != 0 {
+		t.Errorf("expected no margin for a tier that doesn't move, got %v", m)
+	}
+	found := FindWithinEpoch([]*Tier{tier}, end, 0.01, 1,
+		geom.JulianEpoch(2100))
+	if len(found) != 1 || found[0] != barnard {
+		t.Errorf("expected barnard's star at its J2100 position, got %v",
+			found)
+	}
+	_, arcturus := find("../data/bright.tsv", 69673)
+	start, _ = arcturus.Position(CatalogEpoch)
+	end, _ = arcturus.Position(geom.JulianEpoch(4000))
+	/* about 2.3 arc seconds a year */
+	if dist := start.AngularDistance(end); dist < 1.2 || dist > 1.35 {
+		t.Errorf("expected arcturus to move about 1.27 degrees, got %v", dist)
+	}
+	/* mu cas, delta pav, eps ind, gliese 1 and groombridge 34 */
+	for file, hips := range map[string][]int32{
+		"../data/bright.tsv": {5336, 99240, 108870},
+		"../data/tier4.tsv":  {439, 1475},
+	} {
+		for _, hip := range hips {
+			if _, s := find(file, hip); !s.Moves() {
+				t.Errorf("expected hip %v in %v to move", hip, file)
+			}
+		}
+	}
+}
+
+func TestGridTile(t *testing.T) {
+	chars, charsErr = loadChars("../data/chars.png")
+	queries := []string{
+		"BBOX=0,-90,24,90",
+		"BBOX=23,-10,25,20&STYLES=galactic",
+		"BBOX=3,88,2,89&STYLES=ecliptic",
+		"BBOX=24,50,0,90&PROJECTION=stereographic",
+		"CRS=STARMAP:GALACTIC&BBOX=-180,-90,180,90&STYLES=galactic",
+	}
+	for _, query := range queries {
+		r := httptest.NewRequest("GET", "/wms?WIDTH=256&HEIGHT=256"+
+			"&LAYERS=grid&FORMAT=image/png&"+query, nil)
+		w := httptest.NewRecorder()
+		getmap(w, r)
+		if w.Code != 200 {
+			t.Errorf("%v: unexpected status %v: %v", query, w.Code,
+				w.Body.String())
+			continue
+		}
+		img, err := png.Decode(w.Body)
+		if err != nil {
+			t.Errorf("%v: can't decode: %v", query, err)
+			continue
+		}
+		drawn := 0
+		bounds := img.Bounds()
+		for x := bounds.Min.X; x < bounds.Max.X; x += 1 {
+			for y := bounds.Min.Y; y < bounds.Max.Y; y += 1 {
+				if _, _, _, a := img.At(x, y).RGBA(); a != 0 {
+					drawn += 1
+				}
+			}
+		}
+		if drawn == 0 {
+			t.Errorf("%v: expected grid lines", query)
+		}
+	}
+}
+
+func TestGridLabels(t *testing.T) {
+	tests := map[string]string{
+		formatHours(24, 1):            "0h",
+		formatHours(5.25, 0.25):       "5h15m",
+		formatLatitude(-30, 10):       "-30",
+		formatLatitude(0, 10):         "0",
+		formatLatitude(12.5, 0.5):     "+12d30'",
+		formatLongitude(-30, 30):      "330",
+		formatLongitude(360.25, 0.25): "0d15'",
+	}
+	for res, exp := range tests {
+		if res != exp {
+			t.Errorf("expected %v, got %v", exp, res)
+		}
+	}
+	/* about 15 degrees per 100 pixels */
+	xs, step := gridSteps(0, 90, degreeSpacings, 0.15)
+	if step != 15 || len(xs) != 7 {
+		t.Errorf("expected 15 degree steps, got %v: %v", step, xs)
+	}
+	/* too many lines for the whole sky at this scale */
+	xs, step = gridSteps(0, 360, degreeSpacings, 0.01)
+	if len(xs) > maxGridLines {
+		t.Errorf("expected at most %v lines, got %v", maxGridLines, len(xs))
+	}
+}
+
+func TestReferences(t *testing.T) {
+	names := func(p *geom.Point) map[string]bool {
+		rval := make(map[string]bool)
+		for _, ref := range findReferences(p, 0.5) {
+			rval[ref.Name] = true
+		}
+		return rval
+	}
+	equinox := names(geom.NewPoint2D(0, 0))
+	for _, name := range []string{"celestial equator", "ecliptic", "zodiac"} {
+		if !equinox[name] {
+			t.Errorf("expected %v at the equinox, got %v", name, equinox)
+		}
+	}
+	if equinox["galactic equator"] {
+		t.Errorf("unexpected galactic equator at the equinox")
+	}
+	/* galactic center */
+	center := names(geom.NewPoint2D(17.760333, -28.93617))
+	if !center["galactic equator"] || !center["zodiac"] ||
+		center["ecliptic"] {
+		t.Errorf("unexpected references at galactic center %v", center)
+	}
+	if len(names(geom.NewPoint2D(6, 50))) != 0 {
+		t.Errorf("expected no references in auriga")
+	}
+	/* march equinox in 2000 */
+	equinoxTime := time.Date(2000, time.March, 20, 7, 35, 0, 0, time.UTC)
+	lon := geom.SunLongitude(geom.JulianDate(equinoxTime))
+	if lon > 0.02 && lon < 359.98 {
+		t.Errorf("expected sun near the equinox, got %v", lon)
+	}
+	query := "/wms?REQUEST=GetFeatureInfo&LAYERS=references&WIDTH=256" +
+		"&HEIGHT=256&BBOX=1,-8,-1,8&INFO_FORMAT=text/plain&X=128&Y=128"
+	w := httptest.NewRecorder()
+	Handler(w, httptest.NewRequest("GET", query, nil))
+	body := w.Body.Bytes()
+	if !bytes.Contains(body, []byte("celestial equator")) ||
+		!bytes.Contains(body, []byte("Aries")) {
+		t.Errorf("expected equator and zodiac sign, got %s", body)
+	}
+}
+
+func TestObserver(t *testing.T) {
+	catalog = NewCatalog("../data")
+	constelData, constelErr = LoadConstellations("../data/consts",
+		constellationEpoch, CatalogEpoch)
+	chars, charsErr = loadChars("../data/chars.png")
+	query := "/wms?WIDTH=256&HEIGHT=256&LAT=40&LON=-75" +
+		"&TIME=2024-01-15T03:00:00Z&LAYERS=stars,constellations,asterisms"
+	req, err := ParseReq(httptest.NewRequest("GET", query, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.CRS.Name != horizonCRS || req.Projection != domeProjection {
+		t.Errorf("expected dome view, got %v %v", req.CRS.Name,
+			req.Projection)
+	}
+	if lst := req.Observer.SiderealTime(); math.Abs(lst-5.605) > 0.01 {
+		t.Errorf("expected sidereal time near 5.6h, got %v", lst)
+	}
+	trans := req.Trans(geom.STELLAR)
+	/* polaris in the north at the top, orion in the south at the bottom,
+	vega below the horizon */
+	polaris := req.Observer.Horizontal(geom.NewPoint2D(2.53, 89.26))
+	if math.Abs(polaris.Y()-40) > 1 {
+		t.Errorf("expected polaris at altitude 40, got %v", polaris)
+	}
+	pix, ok := trans.TransformVisible(2.53, 89.26)
+	if !ok || pix.Y > 128 {
+		t.Errorf("expected polaris near the top, got %v", pix)
+	}
+	pix, ok = trans.TransformVisible(5.6, -1.2)
+	if !ok || pix.Y < 128 {
+		t.Errorf("expected orion near the bottom, got %v", pix)
+	}
+	if _, ok = trans.TransformVisible(18.6, 38.8); ok {
+		t.Errorf("expected vega below the horizon")
+	}
+	/* east is on the left looking up */
+	east := geom.NewPoint2D(90, 10).FromFrame(req.Observer.frame)
+	pix, ok = trans.TransformVisible(east.X(), east.Y())
+	if !ok || pix.X > 128 {
+		t.Errorf("expected east on the left, got %v", pix)
+	}
+	w := httptest.NewRecorder()
+	getmap(w, httptest.NewRequest("GET", query, nil))
+	if w.Code != 200 {
+		t.Fatalf("unexpected status %v", w.Code)
+	}
+	img, err := png.Decode(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	/* corners are below the horizon */
+	if r, g, b, _ := img.At(2, 2).RGBA(); r != 0 || g != 0 || b != 0 {
+		t.Errorf("expected empty corner, got %v", img.At(2, 2))
+	}
+	/* refraction lifts a star just below the horizon into view unless
+	there is no air */
+	low := geom.NewPoint2D(0, -0.6).FromFrame(req.Observer.frame)
+	if _, ok := trans.TransformVisible(low.X(), low.Y()); !ok {
+		t.Errorf("expected refraction to lift star into view")
+	}
+	high := geom.NewPoint2D(0, 80).FromFrame(req.Observer.frame)
+	if e := req.Observer.Extinction(high); e > 0.25 ||
+		req.Observer.Extinction(low) < 2 {
+		t.Errorf("expected extinction to grow toward the horizon, got %v", e)
+	}
+	airless, err := ParseReq(httptest.NewRequest("GET",
+		query+"&PRESSURE=0", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	trans = airless.Trans(geom.STELLAR)
+	if _, ok := trans.TransformVisible(low.X(), low.Y()); ok {
+		t.Errorf("expected star below the horizon without air")
+	}
+	if airless.Observer.Extinction(high) != 0 {
+		t.Errorf("expected no extinction without air")
+	}
+	if createKey(req).String() == createKey(airless).String() {
+		t.Errorf("expected pressure in cache key")
+	}
+}
+
+func TestRiseSet(t *testing.T) {
+	catalog = NewCatalog("../data")
+	constelData, constelErr = LoadConstellations("../data/consts",
+		constellationEpoch, CatalogEpoch)
+	obs := NewObserver(40, -75, time.Date(2024, time.January, 15, 0, 0, 0,
+		0, time.UTC))
+	/* sirius transits about 23:10 local time at 33 degrees */
+	sirius := obs.RiseSet(geom.NewPoint2D(6.7525, -16.7161))
+	transit := time.Date(2024, time.January, 15, 4, 10, 0, 0, time.UTC)
+	if d := sirius.Transit.Sub(transit); d < -2*time.Minute ||
+		d > 2*time.Minute {
+		t.Errorf("expected transit near %v, got %v", transit, sirius.Transit)
+	}
+	if math.Abs(sirius.MaxAltitude-33.26) > 0.02 || sirius.Circumpolar ||
+		sirius.NeverRises {
+		t.Errorf("unexpected sirius %+v", sirius)
+	}
+	/* one pass, rose the evening before and sets before dawn */
+	if !sirius.Rise.Before(obs.Time) || !sirius.Set.After(sirius.Transit) ||
+		sirius.Transit.Sub(sirius.Rise) != sirius.Set.Sub(sirius.Transit) {
+		t.Errorf("unexpected event order %+v", sirius)
+	}
+	if d := sirius.Set.Sub(sirius.Transit) - 5*time.Hour - 4*time.Minute; d <
+		-5*time.Minute || d > 5*time.Minute {
+		t.Errorf("expected set about 5h after transit, got %v",
+			sirius.Set.Sub(sirius.Transit))
+	}
+	if rs := obs.RiseSet(geom.NewPoint2D(2.53, 89.26)); !rs.Circumpolar ||
+		!rs.Rise.IsZero() {
+		t.Errorf("expected polaris circumpolar, got %+v", rs)
+	}
+	crux := findConstellation("crux")
+	if crux == nil {
+		t.Fatal("expected crux")
+	}
+	if rs := obs.RiseSet(crux.Center()); !rs.NeverRises ||
+		rs.MaxAltitude > 0 {
+		t.Errorf("expected crux to never rise, got %+v", rs)
+	}
+	center := findConstellation("Orion").Center()
+	if math.Abs(center.X()-5.6) > 0.3 || math.Abs(center.Y()-6) > 4 {
+		t.Errorf("unexpected center of orion %v", center)
+	}
+	query := "/wms?REQUEST=GetRiseSet&LAT=40&LON=-75&TIME=2024-01-15" +
+		"&HIP=32349&CONSTELLATION=Orion&INFO_FORMAT=text/plain"
+	w := httptest.NewRecorder()
+	Handler(w, httptest.NewRequest("GET", query, nil))
+	body := w.Body.String()
+	if w.Code != 200 || !strings.Contains(body, "transit: 2024-01-15T04:") ||
+		!strings.Contains(body, "Orion") {
+		t.Errorf("unexpected rise and set output %v", body)
+	}
+	w = httptest.NewRecorder()
+	Handler(w, httptest.NewRequest("GET",
+		"/wms?REQUEST=GetRiseSet&LAT=40&LON=-75&HIP=1", nil))
+	if !strings.Contains(w.Body.String(), InvalidParameterValue) {
+		t.Errorf("expected unknown HIP error, got %v", w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	Handler(w, httptest.NewRequest("GET", strings.Replace(query, "TIME=",
+		"DATE=", 1), nil))
+	if w.Body.String() != body {
+		t.Errorf("expected DATE like TIME at midnight, got %v",
+			w.Body.String())
+	}
+	/* DATE must be a day and can't be given with TIME */
+	for _, bad := range []string{strings.Replace(query, "TIME=2024-01-15",
+		"DATE=tomorrow", 1), query + "&DATE=2024-01-16"} {
+		w = httptest.NewRecorder()
+		Handler(w, httptest.NewRequest("GET", bad, nil))
+		if !strings.Contains(w.Body.String(), InvalidParameterValue) {
+			t.Errorf("%v: expected DATE error, got %v", bad, w.Body.String())
+		}
+	}
+}
+
+func TestMaxMag(t *testing.T) {
+	catalog = NewCatalog("../data")
+	zoomed := "/wms?WIDTH=256&HEIGHT=256&BBOX=6,0,5.75,15"
+	for query, num := range map[string]int{"": 4, "&MAXMAG=6.5": 2,
+		"&BORTLE=9": 1, "&MAXMAG=8": 4, "&MAXMAG=6": 2, "&BORTLE=3": 3,
+		"&BORTLE=2": 4, "&MAXMAG=5.9": 1} {
+		req, err := ParseReq(httptest.NewRequest("GET", zoomed+query, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res := levels(req); res != num {
+			t.Errorf("%v: expected %v levels, got %v", query, num, res)
+		}
+	}
+	gray := func(mag, faintest float64) uint8 {
+		img := render.Create(16, 16, color.Black)
+		trans := geom.CreateTransform(geom.NewPoint2D(16, 0),
+			geom.NewPoint2D(0, 16), 16, 16, geom.STELLAR)
+		drawStar(img, geom.NewPoint2D(8, 8), trans, mag, faintest)
+		var rval uint8
+		for y := 0; y < 16; y += 1 {
+			for x := 0; x < 16; x += 1 {
+				if c := img.At(x, y).(color.RGBA); c.R > rval {
+					rval = c.R
+				}
+			}
+		}
+		return rval
+	}
+	/* the faintest star drawn is the darkest gray */
+	if g := gray(4.3, 4.3); g != minGray {
+		t.Errorf("expected faintest star at %v, got %v", minGray, g)
+	}
+	if g := gray(4.3, math.Inf(1)); g < 200 {
+		t.Errorf("expected bright gray without a limit, got %v", g)
+	}
+	if g := gray(3, 3.5); g != 128 {
+		t.Errorf("expected stretched gray 128, got %v", g)
+	}
+	if g := gray(3, math.Inf(1)); g != 192 {
+		t.Errorf("expected gray 192, got %v", g)
+	}
+	count := func(query string) int {
+		w := httptest.NewRecorder()
+		getmap(w, httptest.NewRequest("GET", "/wms?WIDTH=256&HEIGHT=256"+
+			"&BBOX=8,0,4,30"+query, nil))
+		img, err := png.Decode(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rval := 0
+		bounds := img.Bounds()
+		for y := bounds.Min.Y; y < bounds.Max.Y; y += 1 {
+			for x := bounds.Min.X; x < bounds.Max.X; x += 1 {
+				if r, _, _, _ := img.At(x, y).RGBA(); r > 0 {
+					rval += 1
+				}
+			}
+		}
+		return rval
+	}
+	if city, dark := count("&BORTLE=8"), count("&BORTLE=1"); city >= dark {
+		t.Errorf("expected fewer stars from the city, got %v and %v", city,
+			dark)
+	}
+	/* GetFeatureInfo doesn't find stars GetMap leaves out */
+	data, err := LoadData("../data/tier2.tsv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var faint *Star
+	for _, s := range data {
+		coord, _ := s.Position(CatalogEpoch)
+		if s.Magnitude >= 6.7 && coord.X() > 1 && coord.X() < 23 &&
+			math.Abs(coord.Y()) < 60 {
+			faint = s
+			break
+		}
+	}
+	coord, _ := faint.Position(CatalogEpoch)
+	/* 0.004 hours per pixel draws two tiers */
+	info := fmt.Sprintf("/wms?REQUEST=GetFeatureInfo&WIDTH=256&HEIGHT=256"+
+		"&BBOX=%v,%v,%v,%v&X=128&Y=128&INFO_FORMAT=text/plain",
+		coord.X()-0.512, coord.Y()-5, coord.X()+0.512, coord.Y()+5)
+	found := func(query string) bool {
+		w := httptest.NewRecorder()
+		Handler(w, httptest.NewRequest("GET", info+query, nil))
+		return strings.Contains(w.Body.String(),
+			fmt.Sprintf("hipparcos #: %v\n", faint.HipNum))
+	}
+	if !found("") {
+		t.Errorf("expected hip %v without a limit", faint.HipNum)
+	}
+	if found("&MAXMAG=6.5") {
+		t.Errorf("unexpected hip %v fainter than MAXMAG", faint.HipNum)
+	}
+}
+
+func TestPlanetLayer(t *testing.T) {
+	chars, charsErr = loadChars("../data/chars.png")
+	/* jupiter at opposition */
+	when := "2023-11-03T05:00:00Z"
+	opposition, _ := time.Parse(time.RFC3339, when)
+	e := geom.NewEphemeris(geom.Jupiter, geom.JulianDate(opposition))
+	ra, dec := e.Position.X(), e.Position.Y()
+	bbox := fmt.Sprintf("&BBOX=%v,%v,%v,%v", ra-0.5, dec-7.5, ra+0.5,
+		dec+7.5)
+	query := "/wms?LAYERS=planets&WIDTH=256&HEIGHT=256" + bbox
+	isJupiter := func(at string) bool {
+		w := httptest.NewRecorder()
+		getmap(w, httptest.NewRequest("GET", query+at, nil))
+		img, err := png.Decode(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, g, b, a := img.At(128, 128).RGBA()
+		jr, jg, jb, ja := bodyLooks[geom.Jupiter].color.RGBA()
+		return r == jr && g == jg && b == jb && a == ja
+	}
+	if !isJupiter("&TIME=" + when) {
+		t.Errorf("expected jupiter at the center")
+	}
+	if isJupiter("&TIME=2020-01-01T00:00:00Z") {
+		t.Errorf("expected jupiter elsewhere in 2020")
+	}
+
+	first, err := ParseReq(httptest.NewRequest("GET", query+"&TIME="+when,
+		nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := ParseReq(httptest.NewRequest("GET", query, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *createKey(first) == *createKey(second) {
+		t.Errorf("expected planet tiles for different times to differ")
+	}
+
+	info := "/wms?REQUEST=GetFeatureInfo&INFO_FORMAT=application/json" +
+		"&X=128&Y=128&TIME=" + when + "&" + query[len("/wms?"):]
+	w := httptest.NewRecorder()
+	Handler(w, httptest.NewRequest("GET", info, nil))
+	var res struct {
+		Features []struct {
+			Type       string
+			Properties map[string]interface{}
+		}
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("invalid json %v: %v", w.Body.String(), err)
+	}
+	if len(res.Features) != 1 || res.Features[0].Type != "planet" {
+		t.Fatalf("expected one planet, got %v", res.Features)
+	}
+	props := res.Features[0].Properties
+	if props["name"] != "Jupiter" {
+		t.Errorf("expected jupiter, got %v", props["name"])
+	}
+	for _, key := range []string{"distance_au", "phase", "elongation",
+		"magnitude"} {
+		if _, ok := props[key].(float64); !ok {
+			t.Errorf("expected numeric %v, got %v", key, props[key])
+		}
+	}
+	/* a whole sky map reaches the moon and other planets from jupiter,
+	nearest comes first */
+	wide, err := ParseReq(httptest.NewRequest("GET", "/wms?WIDTH=256"+
+		"&HEIGHT=128&BBOX=0,-90,24,90&TIME="+when, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	trans := wide.Trans(geom.STELLAR)
+	found := planetFeatures(wide, e.Position, trans, 100, 9)
+	if len(found) < 3 || found[0].Params[0].Val != "Jupiter" {
+		t.Fatalf("expected jupiter first of several bodies, got %v", found)
+	}
+	prev := 0.0
+	for _, f := range found {
+		dist := e.Position.AngularDistance(f.Geom.(*geom.Point))
+		if dist < prev {
+			t.Errorf("expected nearest first, got %v after %v", dist, prev)
+		}
+		prev = dist
+	}
+	if one := planetFeatures(wide, e.Position, trans, 100, 1); len(one) != 1 ||
+		one[0].Params[0].Val != "Jupiter" {
+		t.Errorf("expected only jupiter, got %v", one)
+	}
+}
@@ -0,0 +1,193 @@
+package starmap
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+/* returned by tile caches when key isn't found */
+var ErrCacheMiss = errors.New("tile cache miss")
+
+/* identifies a cached tile, includes every parameter that affects output */
+type TileKey struct {
+	Layer  string
+	Width  int
+	Height int
+	/* bounds formatted as minx,miny,maxx,maxy */
+	BBox string
+	/* style, format and any other rendering options */
+	Options string
+}
+
+/* see Stringer interface
+options are hashed to stay within memcache's 250 byte key limit */
+func (k *TileKey) String() string {
+	return fmt.Sprintf("%v-%vx%v-%v-%v", k.Layer, k.Width, k.Height, k.BBox,
+		k.optionsHash())
+}
+
+/* returns hex encoded sha1 of options, options can grow past file name and
+cache key length limits */
+func (k *TileKey) optionsHash() string {
+	sum := sha1.Sum([]byte(k.Options))
+	return hex.EncodeToString(sum[:])
+}
+
+/* storage for encoded tiles */
+type TileCache interface {
+	/* returns tile for key or ErrCacheMiss if not found */
+	Get(r *http.Request, key *TileKey) ([]byte, error)
+	/* stores tile under key */
+	Add(r *http.Request, key *TileKey, tile []byte) error
+}
+
+/* tile cache used by getmap */
+var tiles TileCache = NoCache{}
+
+/* set the tile cache used for getmap requests, call before serving */
+func SetTileCache(c TileCache) {
+	tiles = c
+}
+
+/* cache that never stores anything */
+type NoCache struct{}
+
+/* see TileCache interface */
+func (c NoCache) Get(r *http.Request, key *TileKey) ([]byte, error) {
+	return nil, ErrCacheMiss
+}
+
+/* see TileCache interface */
+func (c NoCache) Add(r *http.Request, key *TileKey, tile []byte) error {
+	return nil
+}
+
+/* entry in LRU list */
+type lruEntry struct {
+	key  string
+	tile []byte
+}
+
+/* in process cache bounded by total tile size in bytes */
+type LRUCache struct {
+	lock     sync.Mutex
+	maxBytes int64
+	size     int64
+	/* front of list is most recently used */
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+/* create a new in memory cache that holds up to maxBytes of tile data */
+func NewLRUCache(maxBytes int64) *LRUCache {
+	return &LRUCache{maxBytes: maxBytes, order: list.New(),
+		entries: make(map[string]*list.Element)}
+}
+
+/* see TileCache interface */
+func (c *LRUCache) Get(r *http.Request, key *TileKey) ([]byte, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	elem, ok := c.entries[key.String()]
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*lruEntry).tile, nil
+}
+
+/* see TileCache interface
+tiles larger than the cache are not stored */
+func (c *LRUCache) Add(r *http.Request, key *TileKey, tile []byte) error {
+	tileSize := int64(len(tile))
+	if tileSize > c.maxBytes {
+		return nil
+	}
+	k := key.String()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if elem, ok := c.entries[k]; ok {
+		c.remove(elem)
+	}
+	for c.size+tileSize > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+	c.entries[k] = c.order.PushFront(&lruEntry{k, tile})
+	c.size += tileSize
+	return nil
+}
+
+/* removes element from cache, lock must be held */
+func (c *LRUCache) remove(elem *list.Element) {
+	entry := c.order.Remove(elem).(*lruEntry)
+	delete(c.entries, entry.key)
+	c.size -= int64(len(entry.tile))
+}
+
+/* returns total size of cached tiles in bytes */
+func (c *LRUCache) Size() int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.size
+}
+
+/* cache that stores tiles on disk under dir/layer/size/bbox/options */
+type DiskCache struct {
+	dir string
+}
+
+/* create a new disk cache rooted at dir */
+func NewDiskCache(dir string) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &DiskCache{dir}, nil
+}
+
+/* returns the file path for key */
+func (c *DiskCache) path(key *TileKey) string {
+	size := fmt.Sprintf("%vx%v", key.Width, key.Height)
+	return filepath.Join(c.dir, url.QueryEscape(key.Layer), size,
+		url.QueryEscape(key.BBox), key.optionsHash()+".tile")
+}
+
+/* see TileCache interface */
+func (c *DiskCache) Get(r *http.Request, key *TileKey) ([]byte, error) {
+	tile, err := ioutil.ReadFile(c.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrCacheMiss
+	}
+	return tile, err
+}
+
+/* see TileCache interface
+writes to a temporary file first so readers never see partial tiles */
+func (c *DiskCache) Add(r *http.Request, key *TileKey, tile []byte) error {
+	fullPath := c.path(key)
+	dir := filepath.Dir(fullPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(tile)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), fullPath)
+}
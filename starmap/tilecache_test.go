@@ -0,0 +1,117 @@
+package starmap
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLRUCache(t *testing.T) {
+	cache := NewLRUCache(10)
+	k1 := &TileKey{"stars", 256, 256, "24,-90,0,90", "format=image/png"}
+	k2 := &TileKey{"stars", 256, 256, "24,-90,0,90", "format=image/jpeg"}
+	k3 := &TileKey{"constellations", 256, 256, "24,-90,0,90", ""}
+	cache.Add(nil, k1, []byte("1234"))
+	cache.Add(nil, k2, []byte("5678"))
+	if _, err := cache.Get(nil, k1); err != nil {
+		t.Errorf("expected k1 cached, got %v", err)
+	}
+	/* k2 is least recently used, should be evicted */
+	cache.Add(nil, k3, []byte("9012"))
+	if _, err := cache.Get(nil, k2); err != ErrCacheMiss {
+		t.Errorf("expected k2 evicted, got %v", err)
+	}
+	if cache.Size() != 8 {
+		t.Errorf("expected 8 bytes cached, got %v", cache.Size())
+	}
+	cache.Add(nil, k2, []byte("too large to cache"))
+	if _, err := cache.Get(nil, k2); err != ErrCacheMiss {
+		t.Errorf("expected oversize tile not cached, got %v", err)
+	}
+}
+
+func TestDiskCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tilecache")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	cache, err := NewDiskCache(dir)
+	if err != nil {
+		t.Fatalf("can't create cache: %v", err)
+	}
+	key := &TileKey{"stars,constellations", 256, 256, "24,-90,0,90",
+		"style=;format=image/png"}
+	if _, err := cache.Get(nil, key); err != ErrCacheMiss {
+		t.Errorf("expected miss, got %v", err)
+	}
+	if err := cache.Add(nil, key, []byte("tile")); err != nil {
+		t.Errorf("can't add: %v", err)
+	}
+	tile, err := cache.Get(nil, key)
+	if err != nil || string(tile) != "tile" {
+		t.Errorf("expected tile, got %v %v", tile, err)
+	}
+}
+
+func TestLongCacheKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tilecache")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	cache, err := NewDiskCache(dir)
+	if err != nil {
+		t.Fatalf("can't create cache: %v", err)
+	}
+	key := &TileKey{"stars", 256, 256, "24,-90,0,90",
+		"style=" + strings.Repeat("outline,", 40) + ";format=image/png"}
+	if name := filepath.Base(cache.path(key)); len(name) > 255 {
+		t.Errorf("expected file name within 255 bytes, got %v", len(name))
+	}
+	if len(key.String()) > 250 {
+		t.Errorf("expected key within 250 bytes, got %v", len(key.String()))
+	}
+	if err := cache.Add(nil, key, []byte("tile")); err != nil {
+		t.Errorf("can't add: %v", err)
+	}
+	other := &TileKey{"stars", 256, 256, "24,-90,0,90", "format=image/png"}
+	if key.String() == other.String() {
+		t.Errorf("expected different options to have different keys")
+	}
+}
+
+/* cache that fails every operation */
+type brokenCache struct{}
+
+/* see TileCache interface */
+func (c brokenCache) Get(r *http.Request, key *TileKey) ([]byte, error) {
+	return nil, errors.New("cache unavailable")
+}
+
+/* see TileCache interface */
+func (c brokenCache) Add(r *http.Request, key *TileKey, tile []byte) error {
+	return errors.New("cache unavailable")
+}
+
+func TestBrokenCache(t *testing.T) {
+	/* nothing to draw, only the cache is under test */
+	constelData, constelErr = nil, nil
+	SetTileCache(brokenCache{})
+	defer SetTileCache(NoCache{})
+	r := httptest.NewRequest("GET", "/wms?REQUEST=GetMap&WIDTH=64&HEIGHT=64"+
+		"&BBOX=6,60,5,70&LAYERS=constellations&FORMAT=image/png", nil)
+	w := httptest.NewRecorder()
+	getmap(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected tile despite cache errors, got %v", w.Code)
+	}
+	if ctype := w.Header().Get("Content-Type"); ctype != "image/png" {
+		t.Errorf("expected image/png, got %v: %v", ctype, w.Body.String())
+	}
+}